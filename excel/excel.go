@@ -13,6 +13,7 @@ var Opts = plugin.PluginOptions{
 				"write_excel_file": Method_write_file,
 				"read_excel_file":  Method_read_file,
 				"modify_rows":      Method_modify_rows,
+				"flatten_rows":     Method_flatten_rows,
 			},
 			InitFunc: excel_initfunc,
 			Description: `Processor sbl.systems/synwork/excel provides methods to handle excel files.
@@ -20,6 +21,8 @@ var Opts = plugin.PluginOptions{
 			actual 
 			  - write_excel_file
 			  - read_excel_file
+			  - modify_rows
+			  - flatten_rows
 			`,
 		}
 	},
@@ -0,0 +1,182 @@
+package excel
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"sbl.systems/go/synwork/plugin-sdk/schema"
+)
+
+type (
+	// conditionSpec holds the value tests of a when block, read_excel_file and modify_rows
+	// test their cells with the same tests and embed it in their conditions
+	conditionSpec struct {
+		Pattern    string
+		NotPattern string
+		Empty      bool
+		NotEmpty   bool
+		Gt         interface{}
+		Lt         interface{}
+		Between    interface{}
+		IsDate     bool
+		IsNumber   bool
+		Equals     interface{}
+	}
+	// conditionError is a problem of a test attribute of a when block
+	conditionError struct {
+		Attr string
+		Err  error
+	}
+	// conditionTests are the compiled value tests of a condition
+	conditionTests struct {
+		pattern    *regexp.Regexp
+		notPattern *regexp.Regexp
+		empty      bool
+		notEmpty   bool
+		gt, lt     *float64
+		between    []float64
+		isDate     bool
+		isNumber   bool
+		equals     interface{}
+	}
+)
+
+// _excel_condition returns the schema of a when block, the selectors choose the cells and
+// any blocks are alternatives of which one has to match
+func _excel_condition(selectors map[string]*schema.Schema) map[string]*schema.Schema {
+	tests := func() map[string]*schema.Schema {
+		elem := map[string]*schema.Schema{
+			"pattern":     {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+			"not-pattern": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+			"empty":       {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+			"not-empty":   {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+			"gt":          {Type: schema.TypeGeneric, Optional: true},
+			"lt":          {Type: schema.TypeGeneric, Optional: true},
+			"between":     {Type: schema.TypeGeneric, Optional: true},
+			"is-date":     {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+			"is-number":   {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+			"equals":      {Type: schema.TypeGeneric, Optional: true},
+		}
+		for key, selector := range selectors {
+			elem[key] = selector
+		}
+		return elem
+	}
+	cond := tests()
+	cond["any"] = &schema.Schema{Type: schema.TypeList, Optional: true, Elem: tests()}
+	return cond
+}
+
+// conditionSpecOf reads the value tests of a when block of the raw configuration
+func conditionSpecOf(raw map[string]interface{}) conditionSpec {
+	spec := conditionSpec{Gt: raw["gt"], Lt: raw["lt"], Between: raw["between"], Equals: raw["equals"]}
+	spec.Pattern, _ = raw["pattern"].(string)
+	spec.NotPattern, _ = raw["not-pattern"].(string)
+	spec.Empty, _ = raw["empty"].(bool)
+	spec.NotEmpty, _ = raw["not-empty"].(bool)
+	spec.IsDate, _ = raw["is-date"].(bool)
+	spec.IsNumber, _ = raw["is-number"].(bool)
+	return spec
+}
+
+// IsSet checks whether the spec has any test, pattern "" matches every value
+func (s conditionSpec) IsSet() bool {
+	return s.Pattern != "" || s.NotPattern != "" || s.Empty || s.NotEmpty || s.Gt != nil || s.Lt != nil ||
+		s.Between != nil || s.IsDate || s.IsNumber || s.Equals != nil
+}
+
+// Compile checks the tests and compiles their regular expressions
+func (s conditionSpec) Compile() (*conditionTests, error) {
+	t := &conditionTests{
+		empty:    s.Empty,
+		notEmpty: s.NotEmpty,
+		isDate:   s.IsDate,
+		isNumber: s.IsNumber,
+		equals:   s.Equals,
+	}
+	var err error
+	if s.Empty && s.NotEmpty {
+		return nil, &conditionError{"not-empty", fmt.Errorf("empty and not-empty exclude each other")}
+	}
+	if t.pattern, err = regexp.Compile(s.Pattern); err != nil {
+		return nil, &conditionError{"pattern", err}
+	}
+	if s.NotPattern != "" {
+		if t.notPattern, err = regexp.Compile(s.NotPattern); err != nil {
+			return nil, &conditionError{"not-pattern", err}
+		}
+	}
+	if s.Gt != nil {
+		if gt, ok := exprNumber(s.Gt); !ok {
+			return nil, &conditionError{"gt", fmt.Errorf("%v is not a number", s.Gt)}
+		} else {
+			t.gt = &gt
+		}
+	}
+	if s.Lt != nil {
+		if lt, ok := exprNumber(s.Lt); !ok {
+			return nil, &conditionError{"lt", fmt.Errorf("%v is not a number", s.Lt)}
+		} else {
+			t.lt = &lt
+		}
+	}
+	if s.Between != nil {
+		bounds, ok := s.Between.([]interface{})
+		if !ok || len(bounds) != 2 {
+			return nil, &conditionError{"between", fmt.Errorf("expected [low, high], got %v", s.Between)}
+		}
+		low, lowOk := exprNumber(bounds[0])
+		high, highOk := exprNumber(bounds[1])
+		if !lowOk || !highOk || high < low {
+			return nil, &conditionError{"between", fmt.Errorf("invalid range %v", s.Between)}
+		}
+		t.between = []float64{low, high}
+	}
+	return t, nil
+}
+
+func (e *conditionError) Error() string {
+	return e.Attr + ": " + e.Err.Error()
+}
+
+// Typed checks whether the tests need the typed value of the cell
+func (t *conditionTests) Typed() bool {
+	return t.gt != nil || t.lt != nil || t.between != nil || t.isDate || t.isNumber || t.equals != nil
+}
+
+// Match tests the text of a cell with the patterns and the emptiness tests, the other
+// tests use the typed value returned by typed
+func (t *conditionTests) Match(text string, typed func() (interface{}, error)) (bool, error) {
+	empty := strings.TrimSpace(text) == ""
+	if t.empty && !empty || t.notEmpty && empty {
+		return false, nil
+	}
+	if !t.pattern.MatchString(text) || t.notPattern != nil && t.notPattern.MatchString(text) {
+		return false, nil
+	}
+	if !t.Typed() {
+		return true, nil
+	}
+	value, err := typed()
+	if err != nil {
+		return false, err
+	}
+	number, isNumber := exprNumber(value)
+	switch {
+	case t.isNumber && !isNumber,
+		t.gt != nil && !(isNumber && number > *t.gt),
+		t.lt != nil && !(isNumber && number < *t.lt),
+		t.between != nil && !(isNumber && t.between[0] <= number && number <= t.between[1]):
+		return false, nil
+	}
+	if t.isDate {
+		if _, ok := sortDate(value); !ok {
+			return false, nil
+		}
+	}
+	if t.equals != nil && !exprCompare("==", value, t.equals) {
+		return false, nil
+	}
+	return true, nil
+}
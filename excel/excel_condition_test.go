@@ -0,0 +1,145 @@
+package excel
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+func TestConditionReadRow(t *testing.T) {
+	values := []string{"AA", "", "12.5", "Subtotal", "2021-03-01"}
+	for _, tc := range []struct {
+		cond *ExcelReadRowCondition
+		want bool
+	}{
+		{&ExcelReadRowCondition{Col: "A", conditionSpec: conditionSpec{Pattern: "^AA$"}}, true},
+		{&ExcelReadRowCondition{Col: "A", conditionSpec: conditionSpec{NotPattern: "^AA$"}}, false},
+		{&ExcelReadRowCondition{Col: "B", conditionSpec: conditionSpec{Empty: true}}, true},
+		{&ExcelReadRowCondition{Col: "B", conditionSpec: conditionSpec{NotEmpty: true}}, false},
+		{&ExcelReadRowCondition{Col: "Z", conditionSpec: conditionSpec{Empty: true}}, true},
+		{&ExcelReadRowCondition{Col: "Z", conditionSpec: conditionSpec{Pattern: ""}}, false},
+		{&ExcelReadRowCondition{Col: "C", conditionSpec: conditionSpec{Gt: 12}}, true},
+		{&ExcelReadRowCondition{Col: "C", conditionSpec: conditionSpec{Lt: 12}}, false},
+		{&ExcelReadRowCondition{Col: "C", conditionSpec: conditionSpec{Between: []interface{}{10, 12.5}}}, true},
+		{&ExcelReadRowCondition{Col: "C", conditionSpec: conditionSpec{IsNumber: true}}, true},
+		{&ExcelReadRowCondition{Col: "D", conditionSpec: conditionSpec{IsNumber: true}}, false},
+		{&ExcelReadRowCondition{Col: "C", conditionSpec: conditionSpec{Equals: 12.5}}, true},
+		{&ExcelReadRowCondition{Col: "D", conditionSpec: conditionSpec{Equals: "Total"}}, false},
+		{&ExcelReadRowCondition{Col: "E", conditionSpec: conditionSpec{IsDate: true}}, true},
+		{&ExcelReadRowCondition{Col: "D", conditionSpec: conditionSpec{IsDate: true}}, false},
+		{&ExcelReadRowCondition{Any: ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^X$"}}, {Col: "D", conditionSpec: conditionSpec{Pattern: "^Sub"}}}}, true},
+		{&ExcelReadRowCondition{Any: ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^X$"}}, {Col: "B", conditionSpec: conditionSpec{NotEmpty: true}}}}, false},
+		{&ExcelReadRowCondition{Col: "A", conditionSpec: conditionSpec{Pattern: "^AA$"}, Any: ExcelReadRowConditions{{Col: "C", conditionSpec: conditionSpec{Gt: 100}}}}, false},
+	} {
+		got, err := ExcelReadRowConditions{tc.cond}.Test(&readRowSource{values: values})
+		if err != nil {
+			t.Fatal(err)
+		} else if got != tc.want {
+			t.Errorf("condition %+v: expected %v, got %v", tc.cond, tc.want, got)
+		}
+	}
+}
+
+func TestConditionReadValidate(t *testing.T) {
+	repository := testRepository(ExcelReadRowConditions{
+		{Col: "A", conditionSpec: conditionSpec{Gt: "many"}},
+		{Any: ExcelReadRowConditions{{Col: "B", conditionSpec: conditionSpec{Between: []interface{}{5, 1}}}, {Col: "C", conditionSpec: conditionSpec{NotPattern: "("}}}},
+		{Col: "D", conditionSpec: conditionSpec{Empty: true, NotEmpty: true}},
+	}, ExcelReadRowCells{{Col: "A", Tag: "sign", Type: cellTypeAuto}})
+	err := repository.Validate()
+	if err == nil {
+		t.Fatal("invalid conditions accepted")
+	}
+	for _, path := range []string{
+		`row["standard"].when[0].gt`,
+		`row["standard"].when[1].any[0].between`,
+		`row["standard"].when[1].any[1].not-pattern`,
+		`row["standard"].when[2].not-empty`,
+	} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("problem %s not reported in %v", path, err)
+		}
+	}
+}
+
+func TestConditionReadTypedCells(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "typed.xlsx")
+	f := excelize.NewFile()
+	dateStyle, err := f.NewStyle(`{"number_format":14}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWriteRows(t, f, "Sheet1", [][]interface{}{
+		{"AA", 44197, 5}, {"AA", "no date", 50}, {"AA", 44228, 500},
+	})
+	for _, axis := range []string{"B1", "B3"} {
+		if err := f.SetCellStyle("Sheet1", axis, axis, dateStyle); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+	repository := testRepository(
+		ExcelReadRowConditions{{Col: "B", conditionSpec: conditionSpec{IsDate: true}}, {Col: "C", conditionSpec: conditionSpec{Lt: 100}}},
+		ExcelReadRowCells{{Col: "C", Tag: "value", Type: cellTypeString}},
+	)
+	if err := repository.Validate(); err != nil {
+		t.Fatal(err)
+	} else if !repository.NeedsCellMeta() {
+		t.Fatal("typed conditions need the cell metadata")
+	}
+	sheets, err := excel_read_sheets(fileName, repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sheets[0].Rows) != 1 || sheets[0].Rows[0].Index != 1 {
+		t.Fatalf("expected row 1 only, got %d rows", len(sheets[0].Rows))
+	}
+}
+
+func TestConditionModifyCells(t *testing.T) {
+	cols := ExcelDataCols{
+		{Col: "A", Tag: "text", Value: "Book"},
+		{Col: "B", Tag: "net", Value: 20.5},
+		{Col: "C", Tag: "vat", Value: 7},
+		{Col: "D", Tag: "date", Value: "2021-03-01T00:00:00Z"},
+		{Col: "E", Tag: "note", Value: nil},
+	}
+	for _, tc := range []struct {
+		cond *ExcelModifyCellCond
+		want bool
+	}{
+		{&ExcelModifyCellCond{Tag: "^text$", conditionSpec: conditionSpec{Pattern: "^Bo"}}, true},
+		{&ExcelModifyCellCond{Tag: "^text$", conditionSpec: conditionSpec{NotPattern: "^Bo"}}, false},
+		{&ExcelModifyCellCond{Tag: "^note$", conditionSpec: conditionSpec{Empty: true}}, true},
+		{&ExcelModifyCellCond{Tag: "^missing$", conditionSpec: conditionSpec{Empty: true}}, true},
+		{&ExcelModifyCellCond{Tag: "^missing$", conditionSpec: conditionSpec{NotEmpty: true}}, false},
+		{&ExcelModifyCellCond{Tag: "^net$", conditionSpec: conditionSpec{Gt: 20}}, true},
+		{&ExcelModifyCellCond{Col: "^C$", conditionSpec: conditionSpec{Between: []interface{}{10, 20}}}, false},
+		{&ExcelModifyCellCond{Tag: "^vat$", conditionSpec: conditionSpec{Equals: 7.0}}, true},
+		{&ExcelModifyCellCond{Tag: "^date$", conditionSpec: conditionSpec{IsDate: true}}, true},
+		{&ExcelModifyCellCond{Tag: "^text$", conditionSpec: conditionSpec{IsNumber: true}}, false},
+		{&ExcelModifyCellCond{conditionSpec: conditionSpec{IsNumber: true}}, true},
+		{&ExcelModifyCellCond{Any: ExcelModifyCellConds{{Tag: "^vat$", conditionSpec: conditionSpec{Gt: 10}}, {Tag: "^text$", conditionSpec: conditionSpec{Pattern: "Book"}}}}, true},
+		{&ExcelModifyCellCond{Tag: "^net$", conditionSpec: conditionSpec{Lt: 10}, Any: ExcelModifyCellConds{{Tag: "^text$"}}}, false},
+	} {
+		conds := ExcelModifyCellConds{tc.cond}
+		if err := conds.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := conds.Test(cols, false); err != nil {
+			t.Fatal(err)
+		} else if got != tc.want {
+			t.Errorf("condition %+v: expected %v, got %v", tc.cond, tc.want, got)
+		}
+	}
+	if err := (ExcelModifyCellConds{{Tag: "^vat$", conditionSpec: conditionSpec{Lt: "x"}}}).Compile(); err == nil || !strings.Contains(err.Error(), "lt") {
+		t.Errorf("invalid lt accepted: %v", err)
+	}
+	if err := (ExcelModifyCellConds{{}}).Compile(); err == nil {
+		t.Error("empty condition accepted")
+	}
+}
@@ -0,0 +1,163 @@
+package excel
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"sbl.systems/go/synwork/plugin-sdk/schema"
+	"sbl.systems/go/synwork/plugin-sdk/utils"
+)
+
+var excel_flatten = map[string]*schema.Schema{
+	"sheets": {
+		Type: schema.TypeList,
+		Elem: map[string]*schema.Schema{
+			"name":   {Type: schema.TypeString, Required: true},
+			"index":  {Type: schema.TypeInt, Required: true},
+			"hidden": {Type: schema.TypeBool, Optional: true},
+			"rows": {
+				Type: schema.TypeList,
+				Elem: _excel_row_element(),
+			},
+		},
+	},
+	"prefix": {
+		Type: schema.TypeList,
+		Elem: map[string]*schema.Schema{
+			"row-type": {Type: schema.TypeString, Required: true},
+			"prefix":   {Type: schema.TypeString, Required: true},
+		},
+	},
+	"sheet-field": {Type: schema.TypeString, Optional: true, DefaultValue: "_sheet"},
+	"index-field": {Type: schema.TypeString, Optional: true, DefaultValue: "_index"},
+	"name-field":  {Type: schema.TypeString, Optional: true, DefaultValue: "_name"},
+}
+
+var Method_flatten_rows = &schema.Method{
+	Schema: excel_flatten,
+	Result: map[string]*schema.Schema{
+		// list of maps tag -> value
+		"records": {Type: schema.TypeGeneric},
+	},
+	ExecFunc: excel_flatten_rows,
+	Description: `Method flatten_rows turns the hierarchical rows of read_excel_file or modify_rows
+	into flat records, one record for each row without children.
+
+	method "flatten_rows" "processor-instance" "method-instance" {
+		sheets = $method.excel_read.sheets
+		prefix {
+			row-type = "^order$" // regular expression
+			prefix = "order_"
+		}
+		sheet-field = "_sheet"
+		index-field = "_index"
+		name-field = "_name"
+	}
+
+	A record is a map of the tags of the row and the tags of all its parent rows, the tags
+	are prefixed by the prefix of the first prefix block matching the row type of their row.
+	A tag of a child row must not repeat a tag of its parents, use a prefix to keep them
+	apart. The fields sheet-field, index-field and name-field hold the sheet name, the row
+	index and the row type of the row, an empty field name omits the field. A tag named
+	like one of the fields is an error like in the records of read_excel_file.
+	`,
+}
+
+type (
+	ExcelFlattenConfiguration struct {
+		Sheets     ExcelDataSheets
+		Prefix     ExcelFlattenPrefixes
+		SheetField string
+		IndexField string
+		NameField  string
+	}
+	ExcelFlattenPrefix struct {
+		RowType  string
+		Prefix   string
+		pRowType *regexp.Regexp
+	}
+	ExcelFlattenPrefixes []*ExcelFlattenPrefix
+)
+
+func excel_flatten_rows(ctx context.Context, data *schema.MethodData, client interface{}) error {
+	config := &ExcelFlattenConfiguration{
+		SheetField: data.GetConfig("sheet-field").(string),
+		IndexField: data.GetConfig("index-field").(string),
+		NameField:  data.GetConfig("name-field").(string),
+	}
+	if err := utils.NewDecoder().Decode(&config.Sheets, data.GetConfig("sheets")); err != nil {
+		return err
+	}
+	if err := utils.NewDecoder().Decode(&config.Prefix, data.GetConfig("prefix")); err != nil {
+		return err
+	}
+	for _, prefix := range config.Prefix {
+		var err error
+		if prefix.pRowType, err = regexp.Compile(prefix.RowType); err != nil {
+			return fmt.Errorf("prefix %s: %w", prefix.RowType, err)
+		}
+	}
+	records := []interface{}{}
+	for _, sheet := range config.Sheets {
+		var err error
+		if records, err = config.Flatten(sheet.Name, sheet.Rows, map[string]interface{}{}, records); err != nil {
+			return err
+		}
+	}
+	data.SetResult("records", records)
+	return nil
+}
+
+// Flatten appends a record for every leaf row, parent holds the prefixed values of the parent rows.
+// A tag repeating a tag of the parent rows or named like a field is an error.
+func (c *ExcelFlattenConfiguration) Flatten(sheetName string, rows ExcelDataRows, parent map[string]interface{}, records []interface{}) ([]interface{}, error) {
+	for _, row := range rows {
+		values := make(map[string]interface{}, len(parent)+len(row.Cols))
+		for tag, value := range parent {
+			values[tag] = value
+		}
+		prefix := c.Prefix.Find(row.Name)
+		for _, col := range row.Cols {
+			tag := prefix + col.Tag
+			if tag != "" && (tag == c.SheetField || tag == c.IndexField || tag == c.NameField) {
+				return nil, fmt.Errorf("sheet %s row %d: tag %s collides with the field of the sheet, index or name", sheetName, row.Index, tag)
+			} else if _, ok := parent[tag]; ok {
+				return nil, fmt.Errorf("sheet %s row %d: tag %s collides with a tag of a parent row", sheetName, row.Index, tag)
+			}
+			values[tag] = col.Value
+		}
+		leaf := true
+		for _, child := range row.Children {
+			if len(child.Rows) > 0 {
+				leaf = false
+				var err error
+				if records, err = c.Flatten(sheetName, child.Rows, values, records); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if leaf {
+			if c.SheetField != "" {
+				values[c.SheetField] = sheetName
+			}
+			if c.IndexField != "" {
+				values[c.IndexField] = row.Index
+			}
+			if c.NameField != "" {
+				values[c.NameField] = row.Name
+			}
+			records = append(records, values)
+		}
+	}
+	return records, nil
+}
+
+func (p ExcelFlattenPrefixes) Find(rowType string) string {
+	for _, prefix := range p {
+		if prefix.pRowType.MatchString(rowType) {
+			return prefix.Prefix
+		}
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package excel
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"sbl.systems/go/synwork/plugin-sdk/tunit"
+	"sbl.systems/go/synwork/plugin-sdk/utils"
+)
+
+func TestFlattenRows01(t *testing.T) {
+	type Method struct {
+		Read *modifyRowsResult `json:"read"`
+	}
+	d := json.NewDecoder(bytes.NewReader([]byte(_modifyRowsOrders)))
+	method := &Method{}
+	if err := d.Decode(method); err != nil {
+		t.Fatal(err)
+	}
+	_jsonData, err := utils.NewEncoder().Encode(method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_defs := `
+	method "flatten_rows" "dum" "flat01" {
+		sheets = $method.read.sheets
+		prefix {
+			row-type = "^order$"
+			prefix = "order_"
+		}
+	}
+	`
+	mm := tunit.MethodMock{
+		ProcessorDef: Opts.Provider,
+		InstanceMock: nil,
+		ExecFunc:     excel_flatten_rows,
+		References: map[string]interface{}{
+			"method": _jsonData,
+		},
+	}
+	result := tunit.CallMockMethod(t, mm, _defs)
+	records := struct {
+		Records []map[string]interface{} `json:"records"`
+	}{}
+	if err := utils.NewDecoder().Decode(&records, result); err != nil {
+		t.Fatal(err)
+	}
+	if len(records.Records) != 3 {
+		t.Fatalf("expected 3 leaf records, got %d", len(records.Records))
+	}
+	first := records.Records[0]
+	if first["order_order"] != "A-1" || first["article"] != "pen" || first["part"] != "cap" || first["_sheet"] != "sheet01" {
+		t.Fatalf("invalid record %v", first)
+	}
+	if last := records.Records[2]; last["article"] != "paper" || last["_name"] != "item" {
+		t.Fatalf("invalid record %v", last)
+	}
+}
+
+func TestFlattenRowsCollisions(t *testing.T) {
+	newRows := func(parentTag, childTag string) ExcelDataRows {
+		return ExcelDataRows{{
+			Name:  "order",
+			Index: 1,
+			Cols:  ExcelDataCols{{Col: "A", Tag: parentTag, Value: "A-1"}},
+			Children: ExcelDataChildren{{Name: "item", Rows: ExcelDataRows{
+				{Name: "item", Index: 2, Cols: ExcelDataCols{{Col: "B", Tag: childTag, Value: "pen"}}},
+			}}},
+		}}
+	}
+	config := &ExcelFlattenConfiguration{SheetField: "_sheet", IndexField: "_index", NameField: "_name"}
+	for _, tc := range []struct {
+		rows    ExcelDataRows
+		prefix  string
+		problem string
+	}{
+		{newRows("order", "article"), "", ""},
+		{newRows("order", "order"), "", "row 2: tag order collides with a tag of a parent row"},
+		{newRows("order", "order"), "order_", ""},
+		{newRows("_index", "article"), "", "row 1: tag _index collides with the field"},
+		{newRows("order", "_sheet"), "", "row 2: tag _sheet collides with the field"},
+		{newRows("name", "article"), "_", "row 1: tag _name collides with the field"},
+	} {
+		config.Prefix = ExcelFlattenPrefixes{}
+		if tc.prefix != "" {
+			config.Prefix = append(config.Prefix, &ExcelFlattenPrefix{RowType: "^order$", Prefix: tc.prefix, pRowType: regexp.MustCompile("^order$")})
+		}
+		records, err := config.Flatten("sheet01", tc.rows, map[string]interface{}{}, []interface{}{})
+		if tc.problem == "" && (err != nil || len(records) != 1) {
+			t.Errorf("prefix %q: expected 1 record, got %v %v", tc.prefix, records, err)
+		} else if tc.problem != "" && (err == nil || !strings.Contains(err.Error(), tc.problem)) {
+			t.Errorf("prefix %q: expected problem %s, got %v", tc.prefix, tc.problem, err)
+		}
+	}
+}
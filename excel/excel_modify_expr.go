@@ -0,0 +1,735 @@
+package excel
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The expression language of modify_rows, e.g.
+//
+//	if(vat > 10, upper(trim(text)) & " (" & $A & ")", coalesce(text, "n/a"))
+//
+// identifiers reference cells by tag, $A by column, tag("a tag") and col("A") do the
+// same for names which are no identifiers.
+type (
+	ExcelModifyExprLang struct {
+		Source string
+		root   exprNode
+	}
+	exprEnv struct {
+		row    *ExcelDataRow
+		values []interface{}
+	}
+	exprNode interface {
+		Eval(env *exprEnv) (interface{}, error)
+	}
+	exprLiteral struct {
+		value interface{}
+	}
+	exprTagRef struct {
+		tag string
+	}
+	exprColRef struct {
+		col string
+	}
+	exprUnary struct {
+		op string
+		x  exprNode
+	}
+	exprBinary struct {
+		op   string
+		x, y exprNode
+	}
+	exprCond struct {
+		cond, then, other exprNode
+	}
+	exprCall struct {
+		name string
+		fn   *exprFunc
+		args []exprNode
+	}
+	exprFunc struct {
+		min, max int
+		fn       func(args []interface{}) (interface{}, error)
+	}
+	exprToken struct {
+		kind  exprTokenKind
+		text  string
+		value interface{}
+		pos   int
+	}
+	exprTokenKind int
+	exprParser    struct {
+		tokens []exprToken
+		pos    int
+	}
+)
+
+const (
+	exprTokEOF exprTokenKind = iota
+	exprTokNumber
+	exprTokString
+	exprTokIdent
+	exprTokCol
+	exprTokOp
+)
+
+var exprFunctions = map[string]*exprFunc{
+	"upper": {1, 1, func(a []interface{}) (interface{}, error) { return strings.ToUpper(exprString(a[0])), nil }},
+	"lower": {1, 1, func(a []interface{}) (interface{}, error) { return strings.ToLower(exprString(a[0])), nil }},
+	"trim":  {1, 1, func(a []interface{}) (interface{}, error) { return strings.TrimSpace(exprString(a[0])), nil }},
+	"round": {1, 2, exprRound},
+	"substr": {2, 3, func(a []interface{}) (interface{}, error) {
+		s := []rune(exprString(a[0]))
+		start, err := exprInt(a[1])
+		if err != nil {
+			return nil, err
+		}
+		end := len(s)
+		if len(a) == 3 {
+			if l, err := exprInt(a[2]); err != nil {
+				return nil, err
+			} else if start+l < end {
+				end = start + l
+			}
+		}
+		if start < 0 {
+			start = 0
+		}
+		if start >= end {
+			return "", nil
+		}
+		return string(s[start:end]), nil
+	}},
+	"replace": {3, 3, func(a []interface{}) (interface{}, error) {
+		return strings.ReplaceAll(exprString(a[0]), exprString(a[1]), exprString(a[2])), nil
+	}},
+	"parse_number": {1, 2, exprParseNumber},
+	"parse_date":   {1, 2, exprParseDate},
+	"format_date":  {2, 2, exprFormatDate},
+	"coalesce": {1, -1, func(a []interface{}) (interface{}, error) {
+		for _, v := range a {
+			if v != nil && v != "" {
+				return v, nil
+			}
+		}
+		return nil, nil
+	}},
+}
+
+// Compile parses the expression, errors are reported with the rule name by the caller
+func (e *ExcelModifyExprLang) Compile() error {
+	tokens, err := exprTokenize(e.Source)
+	if err != nil {
+		return err
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseExpr()
+	if err != nil {
+		return err
+	}
+	if tok := p.peek(); tok.kind != exprTokEOF {
+		return fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+	e.root = root
+	return nil
+}
+
+func (e *ExcelModifyExprLang) Eval(values []interface{}, row *ExcelDataRow) (interface{}, error) {
+	if e.root == nil {
+		if err := e.Compile(); err != nil {
+			return nil, err
+		}
+	}
+	return e.root.Eval(&exprEnv{row: row, values: values})
+}
+
+func exprTokenize(src string) ([]exprToken, error) {
+	tokens := []exprToken{}
+	runes := []rune(src)
+	for pos := 0; pos < len(runes); {
+		ch := runes[pos]
+		start := pos
+		switch {
+		case unicode.IsSpace(ch):
+			pos++
+			continue
+		case unicode.IsDigit(ch) || (ch == '.' && pos+1 < len(runes) && unicode.IsDigit(runes[pos+1])):
+			for pos < len(runes) && (unicode.IsDigit(runes[pos]) || runes[pos] == '.') {
+				pos++
+			}
+			text := string(runes[start:pos])
+			var value interface{}
+			if i, err := strconv.Atoi(text); err == nil {
+				value = i
+			} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+				value = f
+			} else {
+				return nil, fmt.Errorf("invalid number %q at position %d", text, start)
+			}
+			tokens = append(tokens, exprToken{kind: exprTokNumber, text: text, value: value, pos: start})
+			continue
+		case ch == '"' || ch == '\'':
+			var sb strings.Builder
+			pos++
+			for ; pos < len(runes) && runes[pos] != ch; pos++ {
+				if runes[pos] == '\\' && pos+1 < len(runes) {
+					pos++
+				}
+				sb.WriteRune(runes[pos])
+			}
+			if pos >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			pos++
+			tokens = append(tokens, exprToken{kind: exprTokString, text: string(runes[start:pos]), value: sb.String(), pos: start})
+			continue
+		case ch == '$':
+			pos++
+			for pos < len(runes) && unicode.IsLetter(runes[pos]) {
+				pos++
+			}
+			if pos == start+1 {
+				return nil, fmt.Errorf("missing column after $ at position %d", start)
+			}
+			tokens = append(tokens, exprToken{kind: exprTokCol, text: strings.ToUpper(string(runes[start+1 : pos])), pos: start})
+			continue
+		case unicode.IsLetter(ch) || ch == '_':
+			for pos < len(runes) && (unicode.IsLetter(runes[pos]) || unicode.IsDigit(runes[pos]) || runes[pos] == '_') {
+				pos++
+			}
+			tokens = append(tokens, exprToken{kind: exprTokIdent, text: string(runes[start:pos]), pos: start})
+			continue
+		}
+		for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "&", "<", ">", "!", "?", ":", "(", ")", ","} {
+			if strings.HasPrefix(string(runes[pos:]), op) {
+				tokens = append(tokens, exprToken{kind: exprTokOp, text: op, pos: start})
+				pos += len(op)
+				goto next_token
+			}
+		}
+		return nil, fmt.Errorf("unexpected character %q at position %d", ch, start)
+	next_token:
+	}
+	return append(tokens, exprToken{kind: exprTokEOF, pos: len(runes)}), nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != exprTokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == exprTokOp && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		if tok.kind == exprTokEOF {
+			return fmt.Errorf("missing %q at end of expression", op)
+		}
+		return fmt.Errorf("expected %q at position %d, found %q", op, tok.pos, tok.text)
+	}
+	return nil
+}
+
+// binary operators by precedence, lowest first
+var exprBinaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"&"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) parseExpr() (exprNode, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+	then, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	other, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &exprCond{cond: cond, then: then, other: other}, nil
+}
+
+func (p *exprParser) parseBinary(level int) (exprNode, error) {
+	if level >= len(exprBinaryLevels) {
+		return p.parseUnary()
+	}
+	x, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		found := false
+		for _, op := range exprBinaryLevels[level] {
+			if tok.kind == exprTokOp && tok.text == op {
+				found = true
+			}
+		}
+		if !found {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &exprBinary{op: tok.text, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		return &exprUnary{op: "!", x: x}, err
+	} else if p.accept("-") {
+		x, err := p.parseUnary()
+		return &exprUnary{op: "-", x: x}, err
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case exprTokNumber, exprTokString:
+		return &exprLiteral{value: tok.value}, nil
+	case exprTokCol:
+		return &exprColRef{col: tok.text}, nil
+	case exprTokIdent:
+		switch tok.text {
+		case "true":
+			return &exprLiteral{value: true}, nil
+		case "false":
+			return &exprLiteral{value: false}, nil
+		case "null":
+			return &exprLiteral{value: nil}, nil
+		}
+		if !p.accept("(") {
+			return &exprTagRef{tag: tok.text}, nil
+		}
+		return p.parseCall(tok)
+	case exprTokOp:
+		if tok.text == "(" {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	case exprTokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+}
+
+func (p *exprParser) parseCall(name exprToken) (exprNode, error) {
+	args := []exprNode{}
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			} else if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch name.text {
+	case "if":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("if at position %d requires 3 arguments", name.pos)
+		}
+		return &exprCond{cond: args[0], then: args[1], other: args[2]}, nil
+	case "tag", "col":
+		var lit *exprLiteral
+		if len(args) == 1 {
+			lit, _ = args[0].(*exprLiteral)
+		}
+		if lit == nil {
+			return nil, fmt.Errorf("%s at position %d requires one string argument", name.text, name.pos)
+		}
+		if name.text == "tag" {
+			return &exprTagRef{tag: exprString(lit.value)}, nil
+		}
+		return &exprColRef{col: strings.ToUpper(exprString(lit.value))}, nil
+	}
+	fn, ok := exprFunctions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at position %d", name.text, name.pos)
+	}
+	if len(args) < fn.min || (fn.max >= 0 && len(args) > fn.max) {
+		return nil, fmt.Errorf("invalid number of arguments for %s at position %d", name.text, name.pos)
+	}
+	return &exprCall{name: name.text, fn: fn, args: args}, nil
+}
+
+func (e *exprLiteral) Eval(env *exprEnv) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e *exprTagRef) Eval(env *exprEnv) (interface{}, error) {
+	for _, col := range env.row.Cols {
+		if col.Tag == e.tag {
+			return col.Value, nil
+		}
+	}
+	return nil, nil
+}
+
+func (e *exprColRef) Eval(env *exprEnv) (interface{}, error) {
+	for _, col := range env.row.Cols {
+		if col.Col == e.col {
+			return col.Value, nil
+		}
+	}
+	return nil, nil
+}
+
+func (e *exprUnary) Eval(env *exprEnv) (interface{}, error) {
+	x, err := e.x.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "!" {
+		return !exprBool(x), nil
+	} else if x == nil {
+		return nil, nil
+	} else if n, ok := exprNumber(x); !ok {
+		return nil, fmt.Errorf("- requires a number, found %v", x)
+	} else if i, ok := x.(int); ok {
+		return -i, nil
+	} else {
+		return -n, nil
+	}
+}
+
+func (e *exprCond) Eval(env *exprEnv) (interface{}, error) {
+	cond, err := e.cond.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if exprBool(cond) {
+		return e.then.Eval(env)
+	}
+	return e.other.Eval(env)
+}
+
+func (e *exprCall) Eval(env *exprEnv) (interface{}, error) {
+	args := make([]interface{}, len(e.args))
+	for idx, arg := range e.args {
+		var err error
+		if args[idx], err = arg.Eval(env); err != nil {
+			return nil, err
+		}
+	}
+	if v, err := e.fn.fn(args); err != nil {
+		return nil, fmt.Errorf("%s: %w", e.name, err)
+	} else {
+		return v, nil
+	}
+}
+
+func (e *exprBinary) Eval(env *exprEnv) (interface{}, error) {
+	x, err := e.x.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "&&":
+		if !exprBool(x) {
+			return false, nil
+		}
+		y, err := e.y.Eval(env)
+		return exprBool(y), err
+	case "||":
+		if exprBool(x) {
+			return true, nil
+		}
+		y, err := e.y.Eval(env)
+		return exprBool(y), err
+	}
+	y, err := e.y.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "&":
+		return exprString(x) + exprString(y), nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		return exprCompare(e.op, x, y), nil
+	}
+	if x == nil || y == nil {
+		return nil, nil
+	}
+	nx, okx := exprNumber(x)
+	ny, oky := exprNumber(y)
+	if !okx || !oky {
+		if e.op == "+" {
+			return exprString(x) + exprString(y), nil
+		}
+		return nil, fmt.Errorf("%s requires numbers, found %v and %v", e.op, x, y)
+	}
+	var result float64
+	switch e.op {
+	case "+":
+		result = nx + ny
+	case "-":
+		result = nx - ny
+	case "*":
+		result = nx * ny
+	case "/":
+		if ny == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result = nx / ny
+	case "%":
+		if ny == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result = math.Mod(nx, ny)
+	}
+	_, ix := x.(int)
+	_, iy := y.(int)
+	if ix && iy && result == math.Trunc(result) {
+		return int(result), nil
+	}
+	return result, nil
+}
+
+func exprCompare(op string, x, y interface{}) bool {
+	var cmp int
+	if nx, ok := exprNumber(x); ok {
+		if ny, ok := exprNumber(y); ok {
+			switch {
+			case nx < ny:
+				cmp = -1
+			case nx > ny:
+				cmp = 1
+			}
+			goto compared
+		}
+	}
+	if x == nil || y == nil {
+		if op == "==" {
+			return x == nil && y == nil
+		} else if op == "!=" {
+			return !(x == nil && y == nil)
+		}
+		return false
+	}
+	cmp = strings.Compare(exprString(x), exprString(y))
+compared:
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func exprNumber(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case float64:
+		return t, true
+	case float32:
+		return float64(t), true
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(t), 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+func exprInt(v interface{}) (int, error) {
+	if n, ok := exprNumber(v); ok {
+		return int(n), nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+func exprString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func exprBool(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	}
+	if n, ok := exprNumber(v); ok {
+		return n != 0
+	}
+	return true
+}
+
+func exprRound(a []interface{}) (interface{}, error) {
+	if a[0] == nil {
+		return nil, nil
+	}
+	n, ok := exprNumber(a[0])
+	if !ok {
+		return nil, fmt.Errorf("%v is not a number", a[0])
+	}
+	places := 0
+	if len(a) == 2 {
+		var err error
+		if places, err = exprInt(a[1]); err != nil {
+			return nil, err
+		}
+	}
+	factor := math.Pow(10, float64(places))
+	result := math.Round(n*factor) / factor
+	if places <= 0 && float64(math.MinInt64) <= result && result < float64(math.MaxInt64) {
+		// whole numbers are ints as long as they fit
+		return int(result), nil
+	}
+	return result, nil
+}
+
+// exprParseNumber parses formatted numbers like "1.234,56 €", the second argument
+// is the decimal separator, default "."
+func exprParseNumber(a []interface{}) (interface{}, error) {
+	if a[0] == nil {
+		return nil, nil
+	} else if n, ok := a[0].(float64); ok {
+		return n, nil
+	} else if i, ok := a[0].(int); ok {
+		return i, nil
+	}
+	decimal := "."
+	if len(a) == 2 {
+		decimal = exprString(a[1])
+	}
+	var sb strings.Builder
+	for _, ch := range exprString(a[0]) {
+		switch {
+		case unicode.IsDigit(ch), ch == '-', ch == '+':
+			sb.WriteRune(ch)
+		case string(ch) == decimal:
+			sb.WriteRune('.')
+		}
+	}
+	if sb.Len() == 0 {
+		return nil, nil
+	}
+	if n, err := strconv.ParseFloat(sb.String(), 64); err != nil {
+		return nil, fmt.Errorf("%q is not a number", a[0])
+	} else {
+		return n, nil
+	}
+}
+
+// exprParseDate parses a date with a layout like dd.MM.yyyy and returns an ISO-8601 timestamp,
+// like read_excel_file does for date cells
+func exprParseDate(a []interface{}) (interface{}, error) {
+	s := strings.TrimSpace(exprString(a[0]))
+	if s == "" {
+		return nil, nil
+	}
+	layouts := readDateLayouts
+	if len(a) == 2 {
+		layouts = []string{exprDateLayout(exprString(a[1]))}
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format(time.RFC3339), nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not a date", s)
+}
+
+// exprFormatDate formats an ISO-8601 timestamp or an excel date number with a layout like dd.MM.yyyy
+func exprFormatDate(a []interface{}) (interface{}, error) {
+	if a[0] == nil || a[0] == "" {
+		return nil, nil
+	}
+	t, err := exprParseDate(a[:1])
+	if err != nil {
+		if n, ok := exprNumber(a[0]); ok {
+			t, err = excel_cell_to_date(&readCellValue{Raw: strconv.FormatFloat(n, 'f', -1, 64)})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	parsed, err := time.Parse(time.RFC3339, t.(string))
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Format(exprDateLayout(exprString(a[1]))), nil
+}
+
+var exprDateTokens = strings.NewReplacer(
+	"yyyy", "2006",
+	"yy", "06",
+	"MM", "01",
+	"dd", "02",
+	"HH", "15",
+	"mm", "04",
+	"ss", "05",
+)
+
+func exprDateLayout(format string) string {
+	return exprDateTokens.Replace(format)
+}
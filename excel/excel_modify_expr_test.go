@@ -0,0 +1,93 @@
+package excel
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestModifyExprLang(t *testing.T) {
+	row := &ExcelDataRow{
+		Name: "standard",
+		Cols: ExcelDataCols{
+			{Col: "A", Tag: "text", Value: "  Book "},
+			{Col: "B", Tag: "net", Value: 20.5},
+			{Col: "C", Tag: "vat", Value: 7},
+			{Col: "D", Tag: "count", Value: "5"},
+			{Col: "E", Tag: "empty", Value: nil},
+			{Col: "F", Tag: "big", Value: 1e20},
+		},
+	}
+	for _, tc := range []struct {
+		source  string
+		want    string
+		problem string
+	}{
+		// precedence and associativity
+		{source: "1 + 2 * 3", want: "int 7"},
+		{source: "(1 + 2) * 3", want: "int 9"},
+		{source: "10 - 4 - 3", want: "int 3"},
+		{source: "2 * 3 % 4", want: "int 2"},
+		{source: "1 + 2 > 2 && 3 < 4", want: "bool true"},
+		{source: "1 == 1 || 1 / 0", want: "bool true"},
+		{source: `"a" & 1 + 2`, want: "string a3"},
+		{source: `vat > 5 ? "high" : "low"`, want: "string high"},
+		{source: "if(vat < 5, 1, 2) * 10", want: "int 20"},
+		// unary operators
+		{source: "-2 * 3", want: "int -6"},
+		{source: "-vat + 10", want: "int 3"},
+		{source: "2 - -3", want: "int 5"},
+		{source: "-count", want: "float64 -5"},
+		{source: "!0", want: "bool true"},
+		{source: "-empty", want: "<nil> <nil>"},
+		// string and number coercion
+		{source: "count * 2", want: "float64 10"},
+		{source: `count + "x"`, want: "string 5x"},
+		{source: "$D + vat", want: "float64 12"},
+		{source: `"5" == 5`, want: "bool true"},
+		{source: `"abc" < "abd"`, want: "bool true"},
+		{source: "net / 2", want: "float64 10.25"},
+		{source: "7 / 2", want: "float64 3.5"},
+		{source: "empty + 1", want: "<nil> <nil>"},
+		{source: `trim(text) & "!"`, want: "string Book!"},
+		{source: "text * 2", problem: "requires numbers"},
+		// division by zero
+		{source: "net / 0", problem: "division by zero"},
+		{source: "vat % (2 - 2)", problem: "division by zero"},
+		// functions
+		{source: "foo(1)", problem: "unknown function foo"},
+		{source: "upper()", problem: "invalid number of arguments for upper"},
+		{source: "round(1, 2, 3)", problem: "invalid number of arguments for round"},
+		{source: "if(1, 2)", problem: "requires 3 arguments"},
+		{source: "tag(text)", problem: "requires one string argument"},
+		{source: "round(text)", problem: "is not a number"},
+		{source: "round(1234.5678, 2)", want: "float64 1234.57"},
+		{source: "round(1234.5, 0)", want: "int 1235"},
+		{source: "round(1250, -2)", want: "int 1300"},
+		{source: "round(-2.5)", want: "int -3"},
+		{source: "round(big)", want: "float64 1e+20"},
+		{source: "round(big, -3)", want: "float64 1e+20"},
+		{source: "round(empty)", want: "<nil> <nil>"},
+		// syntax errors
+		{source: "1 +", problem: "unexpected"},
+		{source: "(1 + 2", problem: `missing ")"`},
+		{source: `"open`, problem: "unterminated string"},
+		{source: "1 # 2", problem: "unexpected character"},
+	} {
+		expr := &ExcelModifyExprLang{Source: tc.source}
+		err := expr.Compile()
+		var got interface{}
+		if err == nil {
+			got, err = expr.Eval(nil, row)
+		}
+		if tc.problem != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.problem) {
+				t.Errorf("%s: expected problem %s, got %v", tc.source, tc.problem, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tc.source, err)
+		} else if s := fmt.Sprintf("%T %v", got, got); s != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.source, tc.want, s)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package excel
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	lookupNoMatchEmpty   = "empty"
+	lookupNoMatchDefault = "default"
+	lookupNoMatchError   = "error"
+	lookupNoMatchDrop    = "drop"
+
+	lookupDuplicatesFirst = "first"
+	lookupDuplicatesLast  = "last"
+	lookupDuplicatesError = "error"
+)
+
+type (
+	ExcelModifyLookup struct {
+		RuleName      string
+		RowType       string
+		Any           bool
+		When          ExcelModifyCellConds
+		Sheet         string
+		SourceRowType string
+		KeyTag        string
+		MatchTag      string
+		Value         ExcelModifyLookupValues
+		NoMatch       string
+		Default       interface{}
+		Duplicates    string
+		sheets        ExcelDataSheets
+		pRowType      *regexp.Regexp
+		index         map[string]*ExcelDataRow
+		noSheet       bool // no sheet matches sheet, every row is handled by no-match
+	}
+	ExcelModifyLookupValue struct {
+		Tag    string
+		NewTag string
+		NewCol string
+	}
+	ExcelModifyLookupValues []*ExcelModifyLookupValue
+)
+
+// Compile checks the options and builds the index over the source sheets, without a source
+// sheet the index stays empty
+func (rule *ExcelModifyLookup) Compile() error {
+	var err error
+	if rule.pRowType, err = regexp.Compile(rule.RowType); err != nil {
+		return err
+	}
+	pSheet, err := regexp.Compile(rule.Sheet)
+	if err != nil {
+		return err
+	}
+	pSourceRowType, err := regexp.Compile(rule.SourceRowType)
+	if err != nil {
+		return err
+	}
+	if rule.KeyTag == "" {
+		return fmt.Errorf("lookup requires key-tag")
+	}
+	if rule.MatchTag == "" {
+		rule.MatchTag = rule.KeyTag
+	}
+	if len(rule.Value) == 0 {
+		return fmt.Errorf("lookup requires at least one value")
+	}
+	switch rule.NoMatch {
+	case lookupNoMatchEmpty, lookupNoMatchDefault, lookupNoMatchError, lookupNoMatchDrop:
+	default:
+		return fmt.Errorf("unknown no-match %s", rule.NoMatch)
+	}
+	switch rule.Duplicates {
+	case lookupDuplicatesFirst, lookupDuplicatesLast, lookupDuplicatesError:
+	default:
+		return fmt.Errorf("unknown duplicates %s", rule.Duplicates)
+	}
+	if err := rule.When.Compile(); err != nil {
+		return err
+	}
+	rule.index = make(map[string]*ExcelDataRow)
+	rule.noSheet = true
+	for _, sheet := range rule.sheets {
+		if pSheet.MatchString(sheet.Name) {
+			rule.noSheet = false
+			if err := rule.indexRows(sheet.Name, sheet.Rows, pSourceRowType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexRows adds the rows of the source row type at any depth to the index
+func (rule *ExcelModifyLookup) indexRows(sheetName string, rows ExcelDataRows, pSourceRowType *regexp.Regexp) error {
+	for _, row := range rows {
+		if pSourceRowType.MatchString(row.Name) {
+			if key, ok := lookupKey(row, rule.KeyTag); ok {
+				if _, exists := rule.index[key]; !exists || rule.Duplicates == lookupDuplicatesLast {
+					rule.index[key] = row
+				} else if rule.Duplicates == lookupDuplicatesError {
+					return fmt.Errorf("sheet %s row %d: duplicate key %s", sheetName, row.Index, key)
+				}
+			}
+		}
+		for _, child := range row.Children {
+			if err := rule.indexRows(sheetName, child.Rows, pSourceRowType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (rule *ExcelModifyLookup) Match(row *ExcelDataRow) (bool, error) {
+	if !rule.pRowType.MatchString(row.Name) {
+		return false, nil
+	}
+	return rule.When.Test(row.Cols, rule.Any)
+}
+
+func (rule *ExcelModifyLookup) Apply(row *ExcelDataRow) (rowAction, error) {
+	key, _ := lookupKey(row, rule.MatchTag)
+	source, ok := rule.index[key]
+	if !ok {
+		switch rule.NoMatch {
+		case lookupNoMatchError:
+			if rule.noSheet {
+				return rowActionKeep, fmt.Errorf("row %d: no sheet matches %s", row.Index, rule.Sheet)
+			}
+			return rowActionKeep, fmt.Errorf("row %d: no match for %s %q", row.Index, rule.MatchTag, key)
+		case lookupNoMatchDrop:
+			return rowActionDrop, nil
+		}
+	}
+	for _, value := range rule.Value {
+		newCol := &ExcelDataCol{Col: value.NewCol, Tag: value.NewTag}
+		if newCol.Tag == "" {
+			newCol.Tag = value.Tag
+		}
+		if ok {
+			for _, col := range source.Cols {
+				if col.Tag == value.Tag {
+					newCol.Value = col.Value
+					if newCol.Col == "" {
+						newCol.Col = col.Col
+					}
+					break
+				}
+			}
+		} else if rule.NoMatch == lookupNoMatchDefault {
+			newCol.Value = rule.Default
+		}
+		row.Cols = append(row.Cols, newCol)
+	}
+	return rowActionKeep, nil
+}
+
+// lookupKey returns the value of the tag as text, numbers read as 10 and "10" give the same key
+func lookupKey(row *ExcelDataRow, tag string) (string, bool) {
+	for _, col := range row.Cols {
+		if col.Tag == tag && col.Value != nil {
+			key := strings.TrimSpace(exprString(col.Value))
+			return key, key != ""
+		}
+	}
+	return "", false
+}
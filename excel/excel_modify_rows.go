@@ -12,12 +12,73 @@ import (
 
 // https://xuri.me/excelize/en/cell.html#SetCellStyle
 var (
+	excel_modify_cell_cond = _excel_condition(map[string]*schema.Schema{
+		"col": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+		"tag": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+	})
+	excel_modify_filter_rows = map[string]*schema.Schema{
+		"rule-name":        {Type: schema.TypeString, Required: true},
+		"row-type":         {Type: schema.TypeString, Optional: true, DefaultValue: ".*"},
+		"any":              {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		"when":             {Type: schema.TypeList, Elem: excel_modify_cell_cond},
+		"promote-children": {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+	}
+	excel_modify_cell_rule = map[string]*schema.Schema{
+		"rule-name": {Type: schema.TypeString, Required: true},
+		"row-type":  {Type: schema.TypeString, Optional: true, DefaultValue: ".*"},
+		"any":       {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		"when":      {Type: schema.TypeList, Elem: excel_modify_cell_cond},
+		"cell":      {Type: schema.TypeList, Required: true, Elem: excel_modify_cell_cond},
+		"new-tag":   {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+		"new-col":   {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+	}
+	excel_modify_aggregate = map[string]*schema.Schema{
+		"rule-name": {Type: schema.TypeString, Required: true},
+		"row-type":  {Type: schema.TypeString, Optional: true, DefaultValue: ".*"},
+		"any":       {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		"when":      {Type: schema.TypeList, Elem: excel_modify_cell_cond},
+		"child":     {Type: schema.TypeString, Optional: true, DefaultValue: ".*"},
+		"tag":       {Type: schema.TypeString, Required: true},
+		"function":  {Type: schema.TypeString, Optional: true, DefaultValue: "sum"},
+		"separator": {Type: schema.TypeString, Optional: true, DefaultValue: ", "},
+		"recursive": {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		"new-cell": {
+			Type: schema.TypeMap,
+			Elem: map[string]*schema.Schema{
+				"col": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+				"tag": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+			},
+		},
+	}
+	excel_modify_lookup = map[string]*schema.Schema{
+		"rule-name":       {Type: schema.TypeString, Required: true},
+		"row-type":        {Type: schema.TypeString, Optional: true, DefaultValue: ".*"},
+		"any":             {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		"when":            {Type: schema.TypeList, Elem: excel_modify_cell_cond},
+		"sheet":           {Type: schema.TypeString, Required: true},
+		"source-row-type": {Type: schema.TypeString, Optional: true, DefaultValue: ".*"},
+		"key-tag":         {Type: schema.TypeString, Required: true},
+		"match-tag":       {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+		"value": {
+			Type:     schema.TypeList,
+			Required: true,
+			Elem: map[string]*schema.Schema{
+				"tag":     {Type: schema.TypeString, Required: true},
+				"new-tag": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+				"new-col": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+			},
+		},
+		"no-match":   {Type: schema.TypeString, Optional: true, DefaultValue: lookupNoMatchEmpty},
+		"default":    {Type: schema.TypeGeneric, Optional: true},
+		"duplicates": {Type: schema.TypeString, Optional: true, DefaultValue: lookupDuplicatesFirst},
+	}
 	excel_modify = map[string]*schema.Schema{
 		"sheets": {
 			Type: schema.TypeList,
 			Elem: map[string]*schema.Schema{
-				"name":  {Type: schema.TypeString, Required: true},
-				"index": {Type: schema.TypeInt, Required: true},
+				"name":   {Type: schema.TypeString, Required: true},
+				"index":  {Type: schema.TypeInt, Required: true},
+				"hidden": {Type: schema.TypeBool, Optional: true},
 				"rows": {
 					Type: schema.TypeList,
 					Elem: _excel_row_element(),
@@ -27,13 +88,28 @@ var (
 		"sheet": {
 			Type: schema.TypeList,
 			Elem: map[string]*schema.Schema{
-				"apply-rules": {Type: schema.TypeString, Required: true},
-				"when": {
+				"apply-rules": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+				"when":        {Type: schema.TypeList, Elem: _excel_sheet_selector()},
+				"distinct": {
+					Type: schema.TypeMap,
+					Elem: map[string]*schema.Schema{
+						"tags": {Type: schema.TypeString, Required: true},
+						"keep": {Type: schema.TypeString, Optional: true, DefaultValue: distinctKeepFirst},
+					},
+				},
+				"sort": {
 					Type: schema.TypeList,
 					Elem: map[string]*schema.Schema{
-						"name": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
-						"low":  {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
-						"high": {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
+						"tag":   {Type: schema.TypeString, Required: true},
+						"order": {Type: schema.TypeString, Optional: true, DefaultValue: sortOrderAsc},
+						"type":  {Type: schema.TypeString, Optional: true, DefaultValue: cellTypeAuto},
+					},
+				},
+				"group-by": {
+					Type: schema.TypeMap,
+					Elem: map[string]*schema.Schema{
+						"tags":     {Type: schema.TypeString, Required: true},
+						"row-type": {Type: schema.TypeString, Optional: true, DefaultValue: "group"},
 					},
 				},
 			},
@@ -43,14 +119,8 @@ var (
 			Elem: map[string]*schema.Schema{
 				"rule-name": {Type: schema.TypeString, Required: true},
 				"row-type":  {Type: schema.TypeString, Optional: true, DefaultValue: ".*"},
-				"when": {
-					Type: schema.TypeList,
-					Elem: map[string]*schema.Schema{
-						"col":     {Type: schema.TypeString, Optional: true, DefaultValue: ""},
-						"tag":     {Type: schema.TypeString, Optional: true, DefaultValue: ""},
-						"pattern": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
-					},
-				},
+				"any":       {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+				"when":      {Type: schema.TypeList, Elem: excel_modify_cell_cond},
 				"new-cell": {
 					Type: schema.TypeMap,
 					Elem: map[string]*schema.Schema{
@@ -65,20 +135,17 @@ var (
 						"tag": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
 					},
 				},
-				"expr": {
-					Type: schema.TypeMap,
-					Elem: map[string]*schema.Schema{
-						"pattern-extract": {
-							Type: schema.TypeMap,
-							Elem: map[string]*schema.Schema{
-								"pattern": {Type: schema.TypeString, Optional: true, DefaultValue: "(.*)"},
-								"group":   {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
-							},
-						},
-					},
-				},
+				// expr is either an expression string or a block with pattern-extract or fix-value
+				"expr": {Type: schema.TypeGeneric, Optional: true},
 			},
 		},
+		"keep-rows":   {Type: schema.TypeList, Optional: true, Elem: excel_modify_filter_rows},
+		"drop-rows":   {Type: schema.TypeList, Optional: true, Elem: excel_modify_filter_rows},
+		"remove-cell": {Type: schema.TypeList, Optional: true, Elem: excel_modify_cell_rule},
+		"rename-tag":  {Type: schema.TypeList, Optional: true, Elem: excel_modify_cell_rule},
+		"move-cell":   {Type: schema.TypeList, Optional: true, Elem: excel_modify_cell_rule},
+		"aggregate":   {Type: schema.TypeList, Optional: true, Elem: excel_modify_aggregate},
+		"lookup":      {Type: schema.TypeList, Optional: true, Elem: excel_modify_lookup},
 	}
 )
 
@@ -99,41 +166,174 @@ var Method_modify_rows = &schema.Method{
 		sheets = $method.excel_read.sheets
 		sheet {
 			when {
-				name = "pattern"
+				name = "pattern" // like the sheet when blocks of read_excel_file
 			}
 			when {
 				low = 1
 				high = 2
 			}
-			apply-rules = "rule1,rule2"
+			apply-rules = "rule1,rule2,no-subtotals"
+			distinct {
+				tags = "customer,article" // rows with the same values of the tags
+				keep = "first" // or last
+			}
+			sort {
+				tag = "date"
+				order = "desc" // asc
+				type = "date" // auto, string, number
+			}
+			sort {
+				tag = "amount"
+			}
+			group-by {
+				tags = "customer"
+				row-type = "customer" // name of the new parent rows
+			}
 		}
 		add-cell {
 			rule-name = "rule1"
 			row-type = "standard"
 			when {
-				col = "D" // tag = "tag1"
-				pattern = ""
+				col = "D" // tag = "tag1", both are regular expressions matching the whole col or tag
+				pattern = "" // regular expression for the value
+			}
+			when {
+				tag = "^vat$"
+				not-empty = true // tests like read_excel_file: not-pattern, empty, gt, lt,
+				between = [0, 25] // is-number, is-date, equals and any blocks
 			}
+			any = false // true: one of the when conditions is sufficient
 			new-cell {
 				col = "AA"
 				tag = "new"
 			}
+			expr = "if(vat > 10, upper(trim(text)) & \" (\" & $A & \")\", coalesce(text, \"n/a\"))"
+		}
+		add-cell {
+			rule-name = "rule2"
+			new-cell {
+				col = "AB"
+				tag = "new2"
+			}
 			from-cell {
 				col = "A"
 				tag = "tag1"
-				index = 1
 			}
+			expr {
+				pattern-extract {
+					pattern = ""
+					group = 1
+				}
+				// or fix-value {
+				//	value = ""
+				// }
+			}
+		}
+	}
+
+	After the rules the rows of the sheet are reduced by distinct, sorted by the sort blocks
+	(the first block is the first key, rows without a value come first, with type auto
+	numbers come before dates before text) and grouped by
+	group-by. group-by creates a parent row of row-type for each combination of the values
+	of tags with the cells of tags, the grouped rows become its children in the same shape
+	read_excel_file returns child rows.
+
+	A when block matches when one of the cells selected by col and tag passes all of its
+	tests and, with any blocks, one of the any blocks matches the row. pattern and
+	not-pattern test the text of the value, gt, lt, between, is-number, is-date and equals
+	the value itself. A missing cell only matches empty.
 
-			pattern-extract {
-				pattern = ""
-				group = 1
+	keep-rows and drop-rows filter rows, both are used like add-cell rules in apply-rules.
+	keep-rows drops all rows of the row-type not matching the when conditions, drop-rows
+	drops the rows of the row-type matching them. The children of a dropped row are dropped
+	too, with promote-children = true they take the place of the dropped row.
+
+		drop-rows {
+			rule-name = "no-subtotals"
+			row-type = "standard"
+			when {
+				tag = "^text$"
+				pattern = "^Subtotal"
 			}
-			fix-value {
-				value = ""
+			promote-children = false
+		}
+
+	remove-cell, rename-tag and move-cell change the cols of the rows of row-type matching
+	the when conditions. The cells are selected by cell blocks, one of them has to match
+	col, tag (regular expressions matching the whole col or tag) and the value against pattern.
+
+		remove-cell {
+			rule-name = "no-notes"
+			cell {
+				tag = "note.*"
 			}
 		}
-	}
+		rename-tag {
+			rule-name = "rename-net"
+			cell {
+				tag = "^net$"
+			}
+			new-tag = "amount"
+		}
+		move-cell {
+			rule-name = "move-vat"
+			cell {
+				col = "^D$"
+			}
+			new-col = "F"
+		}
 
+	aggregate computes a value over the tag of the rows of the child groups matching child
+	(regular expression on the name of the group) and adds it as new cell to the parent row.
+	function is one of sum, count, min, max, avg, first, last or join (with separator).
+	With recursive = true the rows of matching groups at any depth below are used. Children
+	are modified before their parent, so aggregates of children can be aggregated again.
+
+		aggregate {
+			rule-name = "order-total"
+			row-type = "order"
+			child = "^item$"
+			tag = "amount"
+			function = "sum"
+			new-cell {
+				col = "F"
+				tag = "total"
+			}
+		}
+
+	lookup adds values of the rows of another sheet of sheets. The rows of source-row-type
+	of the sheets matching sheet (regular expression) are indexed by the value of key-tag,
+	rows of row-type are looked up by the value of match-tag (default key-tag). Every value
+	block copies the tag of the found row as new cell (new-tag and new-col default to the
+	tag and the col of the source). no-match is one of empty (cells without value),
+	default (cells with the value of default), error or drop (the row is dropped), it also
+	applies to every row when no sheet of sheets matches sheet. duplicates is one of first, last or error for keys found more than once.
+
+		lookup {
+			rule-name = "article-text"
+			row-type = "position"
+			sheet = "^articles$"
+			key-tag = "article-no"
+			match-tag = "article"
+			value {
+				tag = "description"
+				new-tag = "article-text"
+				new-col = "F"
+			}
+			no-match = "default"
+			default = "unknown"
+			duplicates = "first"
+		}
+
+	The expression language of expr references cells of the row by tag (vat, tag("a tag"))
+	or by column ($A, col("A")); missing cells are null. It supports literals ("text", 'text',
+	1.5, true, false, null), arithmetic (+ - * / %), string concatenation (&, or + with a
+	string), comparison (== != < <= > >=), logic (&& || !) and if/else as if(cond, a, b) or
+	cond ? a : b. Functions are upper, lower, trim, round(x[, places]),
+	substr(s, start[, length]) with 0-based start, replace(s, old, new),
+	parse_number(s[, decimal-separator]), parse_date(s[, "dd.MM.yyyy"]),
+	format_date(date, "yyyy-MM-dd HH:mm:ss") and coalesce(a, b, ...). Dates are ISO-8601
+	timestamps like read_excel_file returns them. Parse errors are reported with the rule name.
 	
 	`,
 }
@@ -141,21 +341,31 @@ var Method_modify_rows = &schema.Method{
 type (
 	ExcelModifySheet struct {
 		ApplyRules string
-		When       ExcelModifySheetConditions `snw:"when"`
+		When       ExcelSheetSelectors `snw:"when"`
+		Distinct   *ExcelModifyDistinct
+		Sort       ExcelModifySortKeys
+		GroupBy    *ExcelModifyGroupBy
 	}
 	ExcelModifySheets  []*ExcelModifySheet
 	ExcelModifyAddCell struct {
 		RuleName string
 		RowType  string
+		Any      bool
 		When     ExcelModifyCellConds
 		NewCell  ExcelModifyCell
 		FromCell ExcelModifyCells
 		Expr     ExcelModifyExprStruct
+		pRowType *regexp.Regexp
 	}
 	ExcelModifyCellCond struct {
-		Col     string
-		Tag     string
-		Pattern string
+		Col string
+		Tag string
+		conditionSpec
+		Any     ExcelModifyCellConds
+		pCol    *regexp.Regexp
+		pTag    *regexp.Regexp
+		tests   *conditionTests
+		selects bool
 	}
 	ExcelModifyCellConds []*ExcelModifyCellCond
 	ExcelModifyCell      struct {
@@ -165,34 +375,36 @@ type (
 	}
 	ExcelModifyCells      []*ExcelModifyCell
 	ExcelModifyExpression interface {
-		Eval([]interface{}) (interface{}, error)
+		Eval(values []interface{}, row *ExcelDataRow) (interface{}, error)
 	}
 	ExcelModifyExprStruct struct {
 		Expression ExcelModifyExpression
 	}
 	ExcelModifyConfiguration struct {
 		Sheets ExcelModifySheets
-		Rules  map[string]*ExcelModifyAddCell
+		Rules  map[string]ExcelModifyRule
 		Data   ExcelDataSheets
 	}
-	ExcelModifySheetConditions []ExcelModifySheetCondition
-
-	ExcelModifySheetCondition struct {
-		Name      string
-		Low, High int
-	}
 	ExcelModifyPatternExtract struct {
 		Pattern string
 		Group   int
 		pRegexp *regexp.Regexp
 	}
+	ExcelModifyFixValue struct {
+		Value interface{}
+	}
 )
 
 var exprFactories = map[string]func(v map[string]interface{}) (ExcelModifyExpression, error){
 	"pattern-extract": func(v map[string]interface{}) (ExcelModifyExpression, error) {
 		e := &ExcelModifyPatternExtract{
-			Pattern: v["pattern"].(string),
-			Group:   v["group"].(int),
+			Pattern: "(.*)",
+		}
+		if pattern, ok := v["pattern"].(string); ok {
+			e.Pattern = pattern
+		}
+		if group, ok := v["group"].(int); ok {
+			e.Group = group
 		}
 		if p, err := regexp.Compile(e.Pattern); err != nil {
 			return nil, err
@@ -201,9 +413,16 @@ var exprFactories = map[string]func(v map[string]interface{}) (ExcelModifyExpres
 		}
 		return e, nil
 	},
+	"fix-value": func(v map[string]interface{}) (ExcelModifyExpression, error) {
+		return &ExcelModifyFixValue{Value: v["value"]}, nil
+	},
+}
+
+func (e *ExcelModifyFixValue) Eval(v []interface{}, row *ExcelDataRow) (interface{}, error) {
+	return e.Value, nil
 }
 
-func (e *ExcelModifyPatternExtract) Eval(v []interface{}) (interface{}, error) {
+func (e *ExcelModifyPatternExtract) Eval(v []interface{}, row *ExcelDataRow) (interface{}, error) {
 	if v == nil || len(v) == 0 {
 		return nil, fmt.Errorf("pattern-extract.Eval() missing required parameter")
 	}
@@ -219,7 +438,16 @@ func (e *ExcelModifyPatternExtract) Eval(v []interface{}) (interface{}, error) {
 }
 
 func (e *ExcelModifyExprStruct) UnmarshallStruct(v interface{}) error {
-	j := v.(map[string]interface{})
+	if v == nil {
+		return nil
+	} else if source, ok := v.(string); ok {
+		e.Expression = &ExcelModifyExprLang{Source: source}
+		return nil
+	}
+	j, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid expr %v", v)
+	}
 	for key, factory := range exprFactories {
 		if kv, ok := j[key]; ok {
 			if jkv, ok := kv.(map[string]interface{}); ok {
@@ -235,27 +463,129 @@ func (e *ExcelModifyExprStruct) UnmarshallStruct(v interface{}) error {
 	return fmt.Errorf("no expr found")
 }
 
-func (e ExcelModifySheetConditions) Test(name string, index int) (bool, error) {
-	for _, cond := range e {
-		if ok, err := cond.Test(name, index); err != nil {
+// Compile prepares the regular expressions and tests of the conditions
+func (c ExcelModifyCellConds) Compile() error {
+	for _, cond := range c {
+		cond.selects = cond.Col != "" || cond.Tag != "" || cond.IsSet()
+		if !cond.selects && len(cond.Any) == 0 {
+			return fmt.Errorf("condition requires col, tag, a test or any")
+		}
+		var err error
+		if cond.Col != "" {
+			if cond.pCol, err = excel_modify_full_match(cond.Col); err != nil {
+				return err
+			}
+		}
+		if cond.Tag != "" {
+			if cond.pTag, err = excel_modify_full_match(cond.Tag); err != nil {
+				return err
+			}
+		}
+		if cond.tests, err = cond.conditionSpec.Compile(); err != nil {
+			return err
+		}
+		if err := cond.Any.Compile(); err != nil {
+			return fmt.Errorf("any: %w", err)
+		}
+	}
+	return nil
+}
+
+// excel_modify_full_match compiles the col or tag pattern of a condition to match the whole
+// text, col = "D" must not select AD or DD
+func excel_modify_full_match(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// Test combines the conditions with AND, or with OR when any is set
+func (c ExcelModifyCellConds) Test(cols ExcelDataCols, any bool) (bool, error) {
+	if len(c) == 0 {
+		return true, nil
+	}
+	for _, cond := range c {
+		if ok, err := cond.Test(cols); err != nil {
 			return false, err
-		} else if !ok {
+		} else if ok && any {
+			return true, nil
+		} else if !ok && !any {
 			return false, nil
 		}
 	}
-	return true, nil
+	return !any, nil
+}
+
+// Match checks whether one of the conditions selects the col
+func (c ExcelModifyCellConds) Match(col *ExcelDataCol) (bool, error) {
+	for _, cond := range c {
+		if ok, err := cond.Match(col); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
 }
 
-func (e *ExcelModifySheetCondition) Test(name string, index int) (bool, error) {
-	if e.Name != "" {
-		if pattern, err := regexp.Compile(e.Name); err != nil {
+// Test checks whether one of the cols selected by col or tag matches the tests and one
+// of the any conditions matches the row, a missing col only matches empty
+func (c *ExcelModifyCellCond) Test(cols ExcelDataCols) (bool, error) {
+	if c.selects {
+		found, selected := false, false
+		for _, col := range cols {
+			if c.Selects(col) {
+				selected = true
+				var err error
+				if found, err = c.MatchValue(col); err != nil {
+					return false, err
+				} else if found {
+					break
+				}
+			}
+		}
+		if !found && (selected || !c.tests.empty) {
+			return false, nil
+		}
+	}
+	if len(c.Any) == 0 {
+		return true, nil
+	}
+	return c.Any.Test(cols, true)
+}
+
+// Match checks col and tag of the col and its value against the tests
+func (c *ExcelModifyCellCond) Match(col *ExcelDataCol) (bool, error) {
+	if c.selects {
+		if !c.Selects(col) {
+			return false, nil
+		} else if ok, err := c.MatchValue(col); err != nil || !ok {
 			return false, err
-		} else {
-			return pattern.MatchString(name), nil
 		}
-	} else {
-		return e.Low > 0 && e.Low <= index+1 && index+1 <= e.High, nil
 	}
+	if len(c.Any) == 0 {
+		return true, nil
+	}
+	return c.Any.Match(col)
+}
+
+// Selects checks col and tag of the col
+func (c *ExcelModifyCellCond) Selects(col *ExcelDataCol) bool {
+	if c.pCol != nil && !c.pCol.MatchString(col.Col) {
+		return false
+	}
+	return c.pTag == nil || c.pTag.MatchString(col.Tag)
+}
+
+// MatchValue tests the value of the col, the patterns test its text
+func (c *ExcelModifyCellCond) MatchValue(col *ExcelDataCol) (bool, error) {
+	value := ""
+	if col.Value != nil {
+		value = fmt.Sprint(col.Value)
+	}
+	ok, err := c.tests.Match(value, func() (interface{}, error) {
+		return col.Value, nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("col %s tag %s: %w", col.Col, col.Tag, err)
+	}
+	return ok, nil
 }
 
 func excel_modify_rows(ctx context.Context, data *schema.MethodData, client interface{}) error {
@@ -266,16 +596,19 @@ func excel_modify_rows(ctx context.Context, data *schema.MethodData, client inte
 	result := ExcelDataSheets{}
 	for _, sheet := range config.Data {
 		for _, sheetCond := range config.Sheets {
-			if ok, err := sheetCond.When.Test(sheet.Name, sheet.Index); err != nil {
+			if ok, err := sheetCond.When.Test(sheet.Name, sheet.Index, sheet.Hidden); err != nil {
 				return err
 			} else if ok {
 				if newRows, err := excel_modify_rows_apply(config, sheet.Rows, strings.Split(sheetCond.ApplyRules, ",")); err != nil {
 					return err
+				} else if newRows, err = excel_modify_rows_sheet_operations(sheetCond, newRows); err != nil {
+					return fmt.Errorf("sheet %s: %w", sheet.Name, err)
 				} else if newRows != nil {
 					result = append(result, &ExcelDataSheet{
-						Name:  sheet.Name,
-						Index: sheet.Index,
-						Rows:  newRows,
+						Name:   sheet.Name,
+						Index:  sheet.Index,
+						Hidden: sheet.Hidden,
+						Rows:   newRows,
 					})
 				}
 				goto goto_next
@@ -300,15 +633,18 @@ func excel_modify_rows(ctx context.Context, data *schema.MethodData, client inte
 func excel_modify_rows_apply(config *ExcelModifyConfiguration, rows ExcelDataRows, rules []string) (ExcelDataRows, error) {
 	result := ExcelDataRows{}
 	for _, row := range rows {
-		if newRow, err := excel_modify_rows_apply_row(config, row, rules); err != nil {
+		if newRows, err := excel_modify_rows_apply_row(config, row, rules); err != nil {
 			return nil, err
 		} else {
-			result = append(result, newRow)
+			result = append(result, newRows...)
 		}
 	}
 	return result, nil
 }
-func excel_modify_rows_apply_row(config *ExcelModifyConfiguration, row *ExcelDataRow, rules []string) (*ExcelDataRow, error) {
+
+// excel_modify_rows_apply_row returns the rows replacing row, none if the row is dropped
+// or the rows of its children if they are promoted
+func excel_modify_rows_apply_row(config *ExcelModifyConfiguration, row *ExcelDataRow, rules []string) (ExcelDataRows, error) {
 	newRow := &ExcelDataRow{
 		Name:     row.Name,
 		Index:    row.Index,
@@ -321,48 +657,85 @@ func excel_modify_rows_apply_row(config *ExcelModifyConfiguration, row *ExcelDat
 		}
 		if childRows, err := excel_modify_rows_apply(config, child.Rows, rules); err != nil {
 			return nil, err
-		} else {
+		} else if len(childRows) > 0 {
 			newChild.Rows = childRows
 			newRow.Children = append(newRow.Children, newChild)
 		}
-		newRow.Children = append(newRow.Children, newChild)
 	}
 	newRow.Cols = append(newRow.Cols, row.Cols...)
 	for _, ruleName := range utils.MapArray[string, string](rules, []string{}, strings.TrimSpace) {
 		if rule, ok := config.Rules[ruleName]; ok {
-			if p, err := regexp.Compile(rule.RowType); err != nil {
-				return nil, err
-			} else if !p.MatchString(newRow.Name) {
+			if ok, err := rule.Match(newRow); err != nil {
+				return nil, fmt.Errorf("rule %s: %w", ruleName, err)
+			} else if !ok {
 				continue
 			}
-			values := []interface{}{}
-			for _, sourceField := range rule.FromCell {
-				for _, col := range newRow.Cols {
-					if (col.Col == sourceField.Col && col.Col != "") || (col.Tag == sourceField.Tag && col.Tag != "") {
-						values = append(values, col.Value)
-					}
+			if action, err := rule.Apply(newRow); err != nil {
+				return nil, fmt.Errorf("rule %s: %w", ruleName, err)
+			} else if action == rowActionDrop {
+				return ExcelDataRows{}, nil
+			} else if action == rowActionPromote {
+				promoted := ExcelDataRows{}
+				for _, child := range newRow.Children {
+					promoted = append(promoted, child.Rows...)
 				}
+				return promoted, nil
 			}
-			if rule.Expr.Expression == nil {
-				return nil, fmt.Errorf("expr missed in rule %s", ruleName)
-			}
-			if newValue, err := rule.Expr.Expression.Eval(values); err != nil {
-				return nil, err
-			} else {
-				newRow.Cols = append(newRow.Cols, &ExcelDataCol{
-					Col:   rule.NewCell.Col,
-					Tag:   rule.NewCell.Tag,
-					Value: newValue,
-				})
+		}
+	}
+	return ExcelDataRows{newRow}, nil
+}
+
+func (rule *ExcelModifyAddCell) Compile() error {
+	var err error
+	if rule.pRowType, err = regexp.Compile(rule.RowType); err != nil {
+		return err
+	}
+	if err := rule.When.Compile(); err != nil {
+		return err
+	}
+	if expr, ok := rule.Expr.Expression.(*ExcelModifyExprLang); ok {
+		if err := expr.Compile(); err != nil {
+			return fmt.Errorf("expr: %w", err)
+		}
+	}
+	return nil
+}
+
+func (rule *ExcelModifyAddCell) Match(row *ExcelDataRow) (bool, error) {
+	if !rule.pRowType.MatchString(row.Name) {
+		return false, nil
+	}
+	return rule.When.Test(row.Cols, rule.Any)
+}
+
+func (rule *ExcelModifyAddCell) Apply(row *ExcelDataRow) (rowAction, error) {
+	values := []interface{}{}
+	for _, sourceField := range rule.FromCell {
+		for _, col := range row.Cols {
+			if (col.Col == sourceField.Col && col.Col != "") || (col.Tag == sourceField.Tag && col.Tag != "") {
+				values = append(values, col.Value)
 			}
 		}
 	}
-	return newRow, nil
+	if rule.Expr.Expression == nil {
+		return rowActionKeep, fmt.Errorf("expr missed")
+	}
+	if newValue, err := rule.Expr.Expression.Eval(values, row); err != nil {
+		return rowActionKeep, err
+	} else {
+		row.Cols = append(row.Cols, &ExcelDataCol{
+			Col:   rule.NewCell.Col,
+			Tag:   rule.NewCell.Tag,
+			Value: newValue,
+		})
+	}
+	return rowActionKeep, nil
 }
 
 func excel_modify_rows_config(ctx context.Context, data *schema.MethodData) (*ExcelModifyConfiguration, error) {
 	config := &ExcelModifyConfiguration{
-		Rules: make(map[string]*ExcelModifyAddCell),
+		Rules: make(map[string]ExcelModifyRule),
 	}
 	err := utils.NewDecoder().Decode(&config.Data, data.GetConfig("sheets"))
 	if err != nil {
@@ -372,13 +745,66 @@ func excel_modify_rows_config(ctx context.Context, data *schema.MethodData) (*Ex
 	if err != nil {
 		return nil, err
 	}
+	for idx, sheet := range config.Sheets {
+		if err := sheet.When.Compile(); err != nil {
+			return nil, fmt.Errorf("sheet[%d].%w", idx, err)
+		}
+		if err := sheet.Sort.Compile(); err != nil {
+			return nil, fmt.Errorf("sheet[%d].%w", idx, err)
+		}
+	}
 	cells := make([]ExcelModifyAddCell, 0)
 	err = utils.NewDecoder().Decode(&cells, data.GetConfig("add-cell"))
 	if err != nil {
 		return nil, err
 	}
-	for _, cell := range cells {
-		config.Rules[cell.RuleName] = &cell
+	for idx := range cells {
+		if err := config.AddRule(cells[idx].RuleName, &cells[idx]); err != nil {
+			return nil, err
+		}
+	}
+	for _, kind := range []string{"remove-cell", "rename-tag", "move-cell"} {
+		cellRules := make([]ExcelModifyCellRule, 0)
+		if err := utils.NewDecoder().Decode(&cellRules, data.GetConfig(kind)); err != nil {
+			return nil, err
+		}
+		for idx := range cellRules {
+			cellRules[idx].Kind = kind
+			if err := config.AddRule(cellRules[idx].RuleName, &cellRules[idx]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	aggregates := make([]ExcelModifyAggregate, 0)
+	if err := utils.NewDecoder().Decode(&aggregates, data.GetConfig("aggregate")); err != nil {
+		return nil, err
+	}
+	for idx := range aggregates {
+		if err := config.AddRule(aggregates[idx].RuleName, &aggregates[idx]); err != nil {
+			return nil, err
+		}
+	}
+	lookups := make([]ExcelModifyLookup, 0)
+	if err := utils.NewDecoder().Decode(&lookups, data.GetConfig("lookup")); err != nil {
+		return nil, err
+	}
+	for idx := range lookups {
+		lookups[idx].sheets = config.Data
+		if err := config.AddRule(lookups[idx].RuleName, &lookups[idx]); err != nil {
+			return nil, err
+		}
+	}
+	for _, kind := range []string{"keep-rows", "drop-rows"} {
+		filters := make([]ExcelModifyFilterRows, 0)
+		if err := utils.NewDecoder().Decode(&filters, data.GetConfig(kind)); err != nil {
+			return nil, err
+		}
+		for idx := range filters {
+			filters[idx].Keep = kind == "keep-rows"
+			if err := config.AddRule(filters[idx].RuleName, &filters[idx]); err != nil {
+				return nil, err
+			}
+		}
 	}
 	return config, nil
 }
@@ -3,6 +3,8 @@ package excel
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 
 	"sbl.systems/go/synwork/plugin-sdk/tunit"
@@ -120,3 +122,937 @@ func TestModifyRows01(t *testing.T) {
 		t.Fatal("invalid result cols count")
 	}
 }
+
+const _modifyRowsSheets = `{
+	"read": {
+		"sheets": [
+			{
+				"name":"sheet01",
+				"index": 1,
+				"rows": [
+					{
+						"name": "std",
+						"index": 1,
+						"cols": [
+							{ "col": "A", "tag": "sign", "value": "€ Bücher" },
+							{ "col": "B", "tag": "text", "value": "Kenne ich " },
+							{ "col": "C", "tag": "net", "value": 10 },
+							{ "col": "D", "tag": "vat", "value": 19 }
+						]
+					},
+					{
+						"name": "std",
+						"index": 2,
+						"cols": [
+							{ "col": "A", "tag": "sign", "value": "$ Books" },
+							{ "col": "B", "tag": "text", "value": "Known" },
+							{ "col": "C", "tag": "net", "value": 20 },
+							{ "col": "D", "tag": "vat", "value": 7 }
+						]
+					}
+				]
+			}
+		]
+	}
+}`
+
+type modifyRowsResult struct {
+	Sheets ExcelDataSheets `json:"sheets"`
+}
+
+func callModifyRows(t *testing.T, _json, _defs string) *modifyRowsResult {
+	type Method struct {
+		Read *modifyRowsResult `json:"read"`
+	}
+	d := json.NewDecoder(bytes.NewReader([]byte(_json)))
+	method := &Method{}
+	if err := d.Decode(method); err != nil {
+		t.Fatal(err)
+	}
+	_jsonData, err := utils.NewEncoder().Encode(method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm := tunit.MethodMock{
+		ProcessorDef: Opts.Provider,
+		InstanceMock: nil,
+		ExecFunc:     excel_modify_rows,
+		References: map[string]interface{}{
+			"method": _jsonData,
+		},
+	}
+	result := tunit.CallMockMethod(t, mm, _defs)
+	resultSheets := &modifyRowsResult{}
+	if err := utils.NewDecoder().Decode(resultSheets, result); err != nil {
+		t.Fatal(err)
+	}
+	return resultSheets
+}
+
+// applyModifyRows runs the rules and the sheet operations of sheet on the sheets of _json
+// like modify_rows does, without the mock harness
+func applyModifyRows(t *testing.T, _json string, sheet *ExcelModifySheet, rules map[string]ExcelModifyRule) ExcelDataSheets {
+	t.Helper()
+	type Method struct {
+		Read *modifyRowsResult `json:"read"`
+	}
+	method := &Method{}
+	if err := json.NewDecoder(strings.NewReader(_json)).Decode(method); err != nil {
+		t.Fatal(err)
+	}
+	config := &ExcelModifyConfiguration{
+		Sheets: ExcelModifySheets{sheet},
+		Rules:  make(map[string]ExcelModifyRule),
+		Data:   method.Read.Sheets,
+	}
+	for name, rule := range rules {
+		if lookup, ok := rule.(*ExcelModifyLookup); ok {
+			lookup.sheets = config.Data
+		}
+		if err := config.AddRule(name, rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sheet.When.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sheet.Sort.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range config.Data {
+		if ok, err := sheet.When.Test(data.Name, data.Index, data.Hidden); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			continue
+		}
+		rows, err := excel_modify_rows_apply(config, data.Rows, strings.Split(sheet.ApplyRules, ","))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.Rows, err = excel_modify_rows_sheet_operations(sheet, rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return config.Data
+}
+
+// testPatternExtract builds a pattern-extract expression like the expr block does
+func testPatternExtract(t *testing.T, pattern string, group int) ExcelModifyExpression {
+	t.Helper()
+	expr, err := exprFactories["pattern-extract"](map[string]interface{}{"pattern": pattern, "group": group})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expr
+}
+
+func TestModifyRows02(t *testing.T) {
+	_defs := `
+	method "modify_rows" "dum" "join01" {
+		sheets = $method.read.sheets
+		sheet {
+			when {
+				name = "sheet01"
+			}
+			apply-rules = "rule1"
+		}
+
+		add-cell {
+			rule-name = "rule1"
+			row-type = "std"
+			when {
+				col = "A"
+				pattern = "^€"
+			}
+			when {
+				tag = "^vat$"
+				pattern = "^19$"
+			}
+			new-cell {
+				col = "AA"
+				tag = "woeuro"
+			}
+			from-cell {
+				col = "A"
+			}
+			expr {
+				pattern-extract {
+					pattern = "^. (.*)$"
+					group = 1
+				}
+			}
+		}
+
+	}
+	`
+	check := func(t *testing.T, sheets ExcelDataSheets) {
+		rows := sheets[0].Rows
+		if len(rows[0].Cols) != 5 || len(rows[1].Cols) != 4 {
+			t.Fatal("when conditions not applied")
+		}
+	}
+	t.Run("direct", func(t *testing.T) {
+		check(t, applyModifyRows(t, _modifyRowsSheets, &ExcelModifySheet{
+			When:       ExcelSheetSelectors{{Name: "sheet01"}},
+			ApplyRules: "rule1",
+		}, map[string]ExcelModifyRule{
+			"rule1": &ExcelModifyAddCell{
+				RowType: "std",
+				When: ExcelModifyCellConds{
+					{Col: "A", conditionSpec: conditionSpec{Pattern: "^€"}},
+					{Tag: "^vat$", conditionSpec: conditionSpec{Pattern: "^19$"}},
+				},
+				NewCell:  ExcelModifyCell{Col: "AA", Tag: "woeuro"},
+				FromCell: ExcelModifyCells{{Col: "A"}},
+				Expr:     ExcelModifyExprStruct{Expression: testPatternExtract(t, "^. (.*)$", 1)},
+			},
+		}))
+	})
+	t.Run("mock", func(t *testing.T) {
+		check(t, callModifyRows(t, _modifyRowsSheets, _defs).Sheets)
+	})
+}
+
+func TestModifyRows03(t *testing.T) {
+	_defs := `
+	method "modify_rows" "dum" "join01" {
+		sheets = $method.read.sheets
+		sheet {
+			when {
+				name = "sheet01"
+			}
+			apply-rules = "rule1"
+		}
+
+		add-cell {
+			rule-name = "rule1"
+			row-type = "std"
+			any = true
+			when {
+				col = "A"
+				pattern = "^€"
+			}
+			when {
+				tag = "^net$"
+				pattern = "^20$"
+			}
+			new-cell {
+				col = "AA"
+				tag = "woeuro"
+			}
+			from-cell {
+				col = "A"
+			}
+			expr {
+				pattern-extract {
+					pattern = "^. (.*)$"
+					group = 1
+				}
+			}
+		}
+
+	}
+	`
+	check := func(t *testing.T, sheets ExcelDataSheets) {
+		rows := sheets[0].Rows
+		if len(rows[0].Cols) != 5 || len(rows[1].Cols) != 5 {
+			t.Fatal("any conditions not applied")
+		}
+	}
+	t.Run("direct", func(t *testing.T) {
+		check(t, applyModifyRows(t, _modifyRowsSheets, &ExcelModifySheet{
+			When:       ExcelSheetSelectors{{Name: "sheet01"}},
+			ApplyRules: "rule1",
+		}, map[string]ExcelModifyRule{
+			"rule1": &ExcelModifyAddCell{
+				RowType: "std",
+				Any:     true,
+				When: ExcelModifyCellConds{
+					{Col: "A", conditionSpec: conditionSpec{Pattern: "^€"}},
+					{Tag: "^net$", conditionSpec: conditionSpec{Pattern: "^20$"}},
+				},
+				NewCell:  ExcelModifyCell{Col: "AA", Tag: "woeuro"},
+				FromCell: ExcelModifyCells{{Col: "A"}},
+				Expr:     ExcelModifyExprStruct{Expression: testPatternExtract(t, "^. (.*)$", 1)},
+			},
+		}))
+	})
+	t.Run("mock", func(t *testing.T) {
+		check(t, callModifyRows(t, _modifyRowsSheets, _defs).Sheets)
+	})
+}
+
+func TestModifyRows04(t *testing.T) {
+	_defs := `
+	method "modify_rows" "dum" "join01" {
+		sheets = $method.read.sheets
+		sheet {
+			when {
+				name = "sheet01"
+			}
+			apply-rules = "gross,label"
+		}
+
+		add-cell {
+			rule-name = "gross"
+			new-cell {
+				col = "E"
+				tag = "gross"
+			}
+			expr = "round(net * (1 + vat / 100), 2)"
+		}
+		add-cell {
+			rule-name = "label"
+			new-cell {
+				col = "F"
+				tag = "label"
+			}
+			expr = "vat > 10 ? upper(trim(text)) & \" (\" & $A & \")\" : coalesce(missing, \"reduced\")"
+		}
+
+	}
+	`
+	check := func(t *testing.T, sheets ExcelDataSheets) {
+		rows := sheets[0].Rows
+		if len(rows[0].Cols) != 6 || rows[0].Cols[4].Value != 11.9 || rows[1].Cols[4].Value != 21.4 {
+			t.Fatal("invalid gross value")
+		}
+		if rows[0].Cols[5].Value != "KENNE ICH (€ Bücher)" || rows[1].Cols[5].Value != "reduced" {
+			t.Fatal("invalid label value")
+		}
+	}
+	t.Run("direct", func(t *testing.T) {
+		check(t, applyModifyRows(t, _modifyRowsSheets, &ExcelModifySheet{
+			When:       ExcelSheetSelectors{{Name: "sheet01"}},
+			ApplyRules: "gross,label",
+		}, map[string]ExcelModifyRule{
+			"gross": &ExcelModifyAddCell{
+				NewCell: ExcelModifyCell{Col: "E", Tag: "gross"},
+				Expr:    ExcelModifyExprStruct{Expression: &ExcelModifyExprLang{Source: "round(net * (1 + vat / 100), 2)"}},
+			},
+			"label": &ExcelModifyAddCell{
+				NewCell: ExcelModifyCell{Col: "F", Tag: "label"},
+				Expr: ExcelModifyExprStruct{Expression: &ExcelModifyExprLang{
+					Source: `vat > 10 ? upper(trim(text)) & " (" & $A & ")" : coalesce(missing, "reduced")`,
+				}},
+			},
+		}))
+	})
+	t.Run("mock", func(t *testing.T) {
+		check(t, callModifyRows(t, _modifyRowsSheets, _defs).Sheets)
+	})
+}
+
+func TestModifyRows05(t *testing.T) {
+	_defs := `
+	method "modify_rows" "dum" "join01" {
+		sheets = $method.read.sheets
+		sheet {
+			when {
+				name = "sheet01"
+			}
+			apply-rules = "no-reduced,only-books"
+		}
+
+		drop-rows {
+			rule-name = "no-reduced"
+			row-type = "std"
+			when {
+				tag = "^vat$"
+				pattern = "^7$"
+			}
+		}
+		keep-rows {
+			rule-name = "only-books"
+			when {
+				col = "A"
+				pattern = "(Bücher|Books)"
+			}
+		}
+
+	}
+	`
+	check := func(t *testing.T, sheets ExcelDataSheets) {
+		rows := sheets[0].Rows
+		if len(rows) != 1 || rows[0].Index != 1 {
+			t.Fatal("rows not filtered")
+		}
+	}
+	t.Run("direct", func(t *testing.T) {
+		check(t, applyModifyRows(t, _modifyRowsSheets, &ExcelModifySheet{
+			When:       ExcelSheetSelectors{{Name: "sheet01"}},
+			ApplyRules: "no-reduced,only-books",
+		}, map[string]ExcelModifyRule{
+			"no-reduced": &ExcelModifyFilterRows{
+				RowType: "std",
+				When:    ExcelModifyCellConds{{Tag: "^vat$", conditionSpec: conditionSpec{Pattern: "^7$"}}},
+			},
+			"only-books": &ExcelModifyFilterRows{
+				When: ExcelModifyCellConds{{Col: "A", conditionSpec: conditionSpec{Pattern: "(Bücher|Books)"}}},
+				Keep: true,
+			},
+		}))
+	})
+	t.Run("mock", func(t *testing.T) {
+		check(t, callModifyRows(t, _modifyRowsSheets, _defs).Sheets)
+	})
+}
+
+func TestModifyRows06(t *testing.T) {
+	_defs := `
+	method "modify_rows" "dum" "join01" {
+		sheets = $method.read.sheets
+		sheet {
+			when {
+				name = "sheet01"
+			}
+			apply-rules = "no-text,rename-net,move-vat"
+		}
+
+		remove-cell {
+			rule-name = "no-text"
+			cell {
+				tag = "^text$"
+			}
+			cell {
+				col = "^A$"
+				pattern = "^\\$"
+			}
+		}
+		rename-tag {
+			rule-name = "rename-net"
+			cell {
+				tag = "^net$"
+			}
+			new-tag = "amount"
+		}
+		move-cell {
+			rule-name = "move-vat"
+			cell {
+				tag = "^vat$"
+			}
+			new-col = "F"
+		}
+
+	}
+	`
+	check := func(t *testing.T, sheets ExcelDataSheets) {
+		rows := sheets[0].Rows
+		if len(rows[0].Cols) != 3 || len(rows[1].Cols) != 2 {
+			t.Fatal("cells not removed")
+		}
+		if rows[1].Cols[0].Tag != "amount" || rows[1].Cols[1].Col != "F" {
+			t.Fatal("cells not renamed or moved")
+		}
+	}
+	t.Run("direct", func(t *testing.T) {
+		check(t, applyModifyRows(t, _modifyRowsSheets, &ExcelModifySheet{
+			When:       ExcelSheetSelectors{{Name: "sheet01"}},
+			ApplyRules: "no-text,rename-net,move-vat",
+		}, map[string]ExcelModifyRule{
+			"no-text": &ExcelModifyCellRule{
+				Kind: "remove-cell",
+				Cell: ExcelModifyCellConds{
+					{Tag: "^text$"},
+					{Col: "^A$", conditionSpec: conditionSpec{Pattern: "^\\$"}},
+				},
+			},
+			"rename-net": &ExcelModifyCellRule{
+				Kind:   "rename-tag",
+				Cell:   ExcelModifyCellConds{{Tag: "^net$"}},
+				NewTag: "amount",
+			},
+			"move-vat": &ExcelModifyCellRule{
+				Kind:   "move-cell",
+				Cell:   ExcelModifyCellConds{{Tag: "^vat$"}},
+				NewCol: "F",
+			},
+		}))
+	})
+	t.Run("mock", func(t *testing.T) {
+		check(t, callModifyRows(t, _modifyRowsSheets, _defs).Sheets)
+	})
+}
+
+const _modifyRowsOrders = `{
+	"read": {
+		"sheets": [
+			{
+				"name":"sheet01",
+				"index": 1,
+				"rows": [
+					{
+						"name": "order",
+						"index": 1,
+						"cols": [ { "col": "A", "tag": "order", "value": "A-1" } ],
+						"child": [
+							{
+								"Name": "item",
+								"Rows": [
+									{
+										"name": "item",
+										"index": 2,
+										"cols": [
+											{ "col": "B", "tag": "article", "value": "pen" },
+											{ "col": "C", "tag": "amount", "value": 10 }
+										],
+										"child": [
+											{
+												"Name": "part",
+												"Rows": [
+													{ "name": "part", "index": 3, "cols": [ { "col": "D", "tag": "part", "value": "cap" } ] },
+													{ "name": "part", "index": 4, "cols": [ { "col": "D", "tag": "part", "value": "ink" } ] }
+												]
+											}
+										]
+									},
+									{
+										"name": "item",
+										"index": 5,
+										"cols": [
+											{ "col": "B", "tag": "article", "value": "paper" },
+											{ "col": "C", "tag": "amount", "value": 2.5 }
+										]
+									}
+								]
+							}
+						]
+					}
+				]
+			}
+		]
+	}
+}`
+
+func TestModifyRows07(t *testing.T) {
+	_defs := `
+	method "modify_rows" "dum" "join01" {
+		sheets = $method.read.sheets
+		sheet {
+			when {
+				name = "sheet01"
+			}
+			apply-rules = "parts,total,articles,all-parts"
+		}
+
+		aggregate {
+			rule-name = "parts"
+			row-type = "^item$"
+			child = "^part$"
+			tag = "part"
+			function = "join"
+			separator = "/"
+			new-cell {
+				col = "E"
+				tag = "parts"
+			}
+		}
+		aggregate {
+			rule-name = "total"
+			row-type = "^order$"
+			child = "^item$"
+			tag = "amount"
+			new-cell {
+				col = "F"
+				tag = "total"
+			}
+		}
+		aggregate {
+			rule-name = "articles"
+			row-type = "^order$"
+			child = "^item$"
+			tag = "parts"
+			function = "first"
+			new-cell {
+				col = "G"
+				tag = "first-parts"
+			}
+		}
+		aggregate {
+			rule-name = "all-parts"
+			row-type = "^order$"
+			child = "^part$"
+			tag = "part"
+			function = "count"
+			recursive = true
+			new-cell {
+				col = "H"
+				tag = "part-count"
+			}
+		}
+
+	}
+	`
+	check := func(t *testing.T, sheets ExcelDataSheets) {
+		order := sheets[0].Rows[0]
+		if len(order.Cols) != 4 {
+			t.Fatalf("aggregates not added: %d cols", len(order.Cols))
+		}
+		if order.Cols[1].Value != 12.5 {
+			t.Fatalf("invalid total %v", order.Cols[1].Value)
+		}
+		if order.Cols[2].Value != "cap/ink" {
+			t.Fatalf("children not aggregated before parent: %v", order.Cols[2].Value)
+		}
+		if v, ok := order.Cols[3].Value.(float64); (!ok || v != 2) && order.Cols[3].Value != 2 {
+			t.Fatalf("invalid recursive count %v", order.Cols[3].Value)
+		}
+	}
+	t.Run("direct", func(t *testing.T) {
+		check(t, applyModifyRows(t, _modifyRowsOrders, &ExcelModifySheet{
+			When:       ExcelSheetSelectors{{Name: "sheet01"}},
+			ApplyRules: "parts,total,articles,all-parts",
+		}, map[string]ExcelModifyRule{
+			"parts": &ExcelModifyAggregate{
+				RowType:   "^item$",
+				Child:     "^part$",
+				Tag:       "part",
+				Function:  "join",
+				Separator: "/",
+				NewCell:   ExcelModifyCell{Col: "E", Tag: "parts"},
+			},
+			"total": &ExcelModifyAggregate{
+				RowType:  "^order$",
+				Child:    "^item$",
+				Tag:      "amount",
+				Function: "sum",
+				NewCell:  ExcelModifyCell{Col: "F", Tag: "total"},
+			},
+			"articles": &ExcelModifyAggregate{
+				RowType:  "^order$",
+				Child:    "^item$",
+				Tag:      "parts",
+				Function: "first",
+				NewCell:  ExcelModifyCell{Col: "G", Tag: "first-parts"},
+			},
+			"all-parts": &ExcelModifyAggregate{
+				RowType:   "^order$",
+				Child:     "^part$",
+				Tag:       "part",
+				Function:  "count",
+				Recursive: true,
+				NewCell:   ExcelModifyCell{Col: "H", Tag: "part-count"},
+			},
+		}))
+	})
+	t.Run("mock", func(t *testing.T) {
+		check(t, callModifyRows(t, _modifyRowsOrders, _defs).Sheets)
+	})
+}
+
+const _modifyRowsArticles = `{
+	"read": {
+		"sheets": [
+			{
+				"name":"articles",
+				"index": 1,
+				"rows": [
+					{ "name": "article", "index": 1, "cols": [ { "col": "A", "tag": "article-no", "value": 100 }, { "col": "B", "tag": "description", "value": "pen" } ] },
+					{ "name": "article", "index": 2, "cols": [ { "col": "A", "tag": "article-no", "value": 200 }, { "col": "B", "tag": "description", "value": "paper" } ] },
+					{ "name": "article", "index": 3, "cols": [ { "col": "A", "tag": "article-no", "value": 100 }, { "col": "B", "tag": "description", "value": "pencil" } ] }
+				]
+			},
+			{
+				"name":"positions",
+				"index": 2,
+				"rows": [
+					{ "name": "position", "index": 1, "cols": [ { "col": "A", "tag": "article", "value": "100" } ] },
+					{ "name": "position", "index": 2, "cols": [ { "col": "A", "tag": "article", "value": "300" } ] },
+					{ "name": "position", "index": 3, "cols": [ { "col": "A", "tag": "article", "value": "200" } ] }
+				]
+			}
+		]
+	}
+}`
+
+func TestModifyRows08(t *testing.T) {
+	_defs := `
+	method "modify_rows" "dum" "join01" {
+		sheets = $method.read.sheets
+		sheet {
+			when {
+				name = "positions"
+			}
+			apply-rules = "article-text"
+		}
+
+		lookup {
+			rule-name = "article-text"
+			row-type = "^position$"
+			sheet = "^articles$"
+			key-tag = "article-no"
+			match-tag = "article"
+			value {
+				tag = "description"
+				new-tag = "article-text"
+				new-col = "B"
+			}
+			no-match = "default"
+			default = "unknown"
+			duplicates = "last"
+		}
+
+	}
+	`
+	check := func(t *testing.T, sheets ExcelDataSheets) {
+		rows := sheets[1].Rows
+		if len(rows) != 3 || len(rows[0].Cols) != 2 {
+			t.Fatal("lookup values not added")
+		}
+		if rows[0].Cols[1].Value != "pencil" || rows[1].Cols[1].Value != "unknown" || rows[2].Cols[1].Value != "paper" {
+			t.Fatalf("invalid lookup values %v %v %v", rows[0].Cols[1].Value, rows[1].Cols[1].Value, rows[2].Cols[1].Value)
+		}
+	}
+	t.Run("direct", func(t *testing.T) {
+		check(t, applyModifyRows(t, _modifyRowsArticles, &ExcelModifySheet{
+			When:       ExcelSheetSelectors{{Name: "positions"}},
+			ApplyRules: "article-text",
+		}, map[string]ExcelModifyRule{
+			"article-text": &ExcelModifyLookup{
+				RowType:       "^position$",
+				Sheet:         "^articles$",
+				SourceRowType: ".*",
+				KeyTag:        "article-no",
+				MatchTag:      "article",
+				Value:         ExcelModifyLookupValues{{Tag: "description", NewTag: "article-text", NewCol: "B"}},
+				NoMatch:       lookupNoMatchDefault,
+				Default:       "unknown",
+				Duplicates:    lookupDuplicatesLast,
+			},
+		}))
+	})
+	t.Run("mock", func(t *testing.T) {
+		check(t, callModifyRows(t, _modifyRowsArticles, _defs).Sheets)
+	})
+}
+
+func TestModifyRowsLookupWithoutSheet(t *testing.T) {
+	sheets := ExcelDataSheets{{Name: "positions", Rows: ExcelDataRows{
+		{Name: "position", Index: 1, Cols: ExcelDataCols{{Col: "A", Tag: "article", Value: "A-1"}}},
+	}}}
+	for _, tc := range []struct {
+		noMatch string
+		want    string
+	}{
+		{lookupNoMatchEmpty, "<nil>"},
+		{lookupNoMatchDefault, "unknown"},
+		{lookupNoMatchError, "row 1: no sheet matches ^articles$"},
+		{lookupNoMatchDrop, "dropped"},
+	} {
+		rule := &ExcelModifyLookup{
+			RowType:    "^position$",
+			Sheet:      "^articles$",
+			KeyTag:     "article",
+			Value:      ExcelModifyLookupValues{{Tag: "description"}},
+			NoMatch:    tc.noMatch,
+			Default:    "unknown",
+			Duplicates: lookupDuplicatesFirst,
+			sheets:     sheets,
+		}
+		if err := rule.Compile(); err != nil {
+			t.Fatalf("%s: %v", tc.noMatch, err)
+		}
+		row := &ExcelDataRow{Name: "position", Index: 1, Cols: ExcelDataCols{{Col: "A", Tag: "article", Value: "A-1"}}}
+		got := ""
+		if ok, err := rule.Match(row); err != nil || !ok {
+			t.Fatalf("%s: row not matched: %v", tc.noMatch, err)
+		} else if action, err := rule.Apply(row); err != nil {
+			got = err.Error()
+		} else if action == rowActionDrop {
+			got = "dropped"
+		} else {
+			got = fmt.Sprint(row.Cols[len(row.Cols)-1].Value)
+		}
+		if got != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.noMatch, tc.want, got)
+		}
+	}
+}
+
+func TestModifyRows09(t *testing.T) {
+	_defs := `
+	method "modify_rows" "dum" "join01" {
+		sheets = $method.read.sheets
+		sheet {
+			when {
+				name = "articles"
+			}
+			distinct {
+				tags = "article-no"
+				keep = "last"
+			}
+			sort {
+				tag = "article-no"
+				order = "desc"
+				type = "number"
+			}
+			group-by {
+				tags = "article-no"
+				row-type = "article-group"
+			}
+		}
+
+	}
+	`
+	check := func(t *testing.T, sheets ExcelDataSheets) {
+		rows := sheets[0].Rows
+		if len(rows) != 2 || rows[0].Name != "article-group" {
+			t.Fatal("rows not grouped")
+		}
+		if rows[0].Children[0].Rows[0].Cols[1].Value != "paper" || rows[1].Children[0].Rows[0].Cols[1].Value != "pencil" {
+			t.Fatal("rows not sorted or distinct")
+		}
+	}
+	t.Run("direct", func(t *testing.T) {
+		check(t, applyModifyRows(t, _modifyRowsArticles, &ExcelModifySheet{
+			When:     ExcelSheetSelectors{{Name: "articles"}},
+			Distinct: &ExcelModifyDistinct{Tags: "article-no", Keep: distinctKeepLast},
+			Sort:     ExcelModifySortKeys{{Tag: "article-no", Order: sortOrderDesc, Type: cellTypeNumber}},
+			GroupBy:  &ExcelModifyGroupBy{Tags: "article-no", RowType: "article-group"},
+		}, nil))
+	})
+	t.Run("mock", func(t *testing.T) {
+		check(t, callModifyRows(t, _modifyRowsArticles, _defs).Sheets)
+	})
+}
+
+func TestModifyRowsColumnsPastZ(t *testing.T) {
+	newRow := func() *ExcelDataRow {
+		return &ExcelDataRow{
+			Name:  "standard",
+			Index: 1,
+			Cols: ExcelDataCols{
+				{Col: "A", Tag: "text", Value: "a"},
+				{Col: "D", Tag: "net", Value: "d"},
+				{Col: "AA", Tag: "text2", Value: "x"},
+				{Col: "AD", Tag: "net2", Value: "x"},
+				{Col: "DD", Tag: "net3", Value: "x"},
+			},
+		}
+	}
+	config := &ExcelModifyConfiguration{Rules: make(map[string]ExcelModifyRule)}
+	for name, rule := range map[string]ExcelModifyRule{
+		"add-d": &ExcelModifyAddCell{
+			RowType: "standard",
+			When:    ExcelModifyCellConds{{Col: "D", conditionSpec: conditionSpec{Pattern: "x"}}},
+			NewCell: ExcelModifyCell{Col: "E", Tag: "flag"},
+			Expr:    ExcelModifyExprStruct{Expression: &ExcelModifyFixValue{Value: "set"}},
+		},
+		"remove-a": &ExcelModifyCellRule{
+			Kind:    "remove-cell",
+			RowType: "standard",
+			Cell:    ExcelModifyCellConds{{Col: "A"}},
+		},
+		"remove-net": &ExcelModifyCellRule{
+			Kind:    "remove-cell",
+			RowType: "standard",
+			Cell:    ExcelModifyCellConds{{Tag: "net"}},
+		},
+	} {
+		if err := config.AddRule(name, rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		rule string
+		want string
+	}{
+		{"add-d", "A,D,AA,AD,DD"},
+		{"remove-a", "D,AA,AD,DD"},
+		{"remove-net", "A,AA,AD,DD"},
+	} {
+		rows, err := excel_modify_rows_apply(config, ExcelDataRows{newRow()}, []string{tc.rule})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols := []string{}
+		for _, col := range rows[0].Cols {
+			cols = append(cols, col.Col)
+		}
+		if got := strings.Join(cols, ","); got != tc.want {
+			t.Errorf("rule %s: expected cols %s, got %s", tc.rule, tc.want, got)
+		}
+	}
+}
+
+func TestModifyRowsCellRulesKeepCell(t *testing.T) {
+	for _, tc := range []struct {
+		rule *ExcelModifyCellRule
+		want string
+	}{
+		{&ExcelModifyCellRule{Kind: "rename-tag", RowType: "standard", Cell: ExcelModifyCellConds{{Tag: "group|total"}}, NewTag: "renamed"},
+			"A:renamed=7[A1:A3]cached B:renamed=12[]formula"},
+		{&ExcelModifyCellRule{Kind: "move-cell", RowType: "standard", Cell: ExcelModifyCellConds{{Tag: "group|total"}}, NewCol: "F"},
+			"F:group=7[A1:A3]cached F:total=12[]formula"},
+	} {
+		row := &ExcelDataRow{
+			Name: "standard",
+			Cols: ExcelDataCols{
+				{Col: "A", Tag: "group", Value: 7, Merge: "A1:A3", Source: readSourceCached},
+				{Col: "B", Tag: "total", Value: 12, Source: readSourceFormula},
+			},
+		}
+		if err := tc.rule.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := tc.rule.Match(row); err != nil || !ok {
+			t.Fatalf("%s: row not matched: %v", tc.rule.Kind, err)
+		}
+		if _, err := tc.rule.Apply(row); err != nil {
+			t.Fatal(err)
+		}
+		cols := []string{}
+		for _, col := range row.Cols {
+			cols = append(cols, fmt.Sprintf("%s:%s=%v[%s]%s", col.Col, col.Tag, col.Value, col.Merge, col.Source))
+		}
+		if got := strings.Join(cols, " "); got != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.rule.Kind, tc.want, got)
+		}
+	}
+}
+
+func TestModifyRowsSortMixed(t *testing.T) {
+	values := []interface{}{"10", 2, "2021-03-01T00:00:00Z", "abc", nil, 9.5, "2020-01-01", "Abc"}
+	rows := ExcelDataRows{}
+	for idx, value := range values {
+		rows = append(rows, &ExcelDataRow{
+			Name:  "standard",
+			Index: idx + 1,
+			Cols:  ExcelDataCols{{Col: "A", Tag: "key", Value: value}},
+		})
+	}
+	for _, tc := range []struct {
+		key  *ExcelModifySortKey
+		want string
+	}{
+		{&ExcelModifySortKey{Tag: "key", Order: sortOrderAsc, Type: cellTypeAuto}, "5,2,6,1,7,3,8,4"},
+		{&ExcelModifySortKey{Tag: "key", Order: sortOrderDesc, Type: cellTypeAuto}, "4,8,3,7,1,6,2,5"},
+		{&ExcelModifySortKey{Tag: "key", Order: sortOrderAsc, Type: cellTypeNumber}, "5,2,6,1,7,3,8,4"},
+		{&ExcelModifySortKey{Tag: "key", Order: sortOrderAsc, Type: cellTypeDate}, "5,7,3,1,2,6,8,4"},
+		{&ExcelModifySortKey{Tag: "key", Order: sortOrderAsc, Type: cellTypeString}, "5,1,2,7,3,6,8,4"},
+	} {
+		keys := ExcelModifySortKeys{tc.key}
+		if err := keys.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		sorted, err := keys.Apply(rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		indexes := []string{}
+		for _, row := range sorted {
+			indexes = append(indexes, fmt.Sprint(row.Index))
+		}
+		if got := strings.Join(indexes, ","); got != tc.want {
+			t.Errorf("%s %s: expected %s, got %s", tc.key.Type, tc.key.Order, tc.want, got)
+		}
+	}
+	for _, key := range []*ExcelModifySortKey{
+		{Tag: "key", Order: "up", Type: cellTypeAuto},
+		{Tag: "key", Order: sortOrderAsc, Type: "bool"},
+	} {
+		if err := (ExcelModifySortKeys{key}).Compile(); err == nil || !strings.HasPrefix(err.Error(), "sort[0]") {
+			t.Errorf("invalid sort key %+v accepted: %v", key, err)
+		}
+	}
+}
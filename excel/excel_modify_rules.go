@@ -0,0 +1,304 @@
+package excel
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+const (
+	rowActionKeep rowAction = iota
+	rowActionDrop
+	rowActionPromote
+)
+
+type (
+	rowAction int
+	// ExcelModifyRule is a rule of modify_rows referenced by its rule-name in apply-rules
+	ExcelModifyRule interface {
+		Compile() error
+		Match(row *ExcelDataRow) (bool, error)
+		Apply(row *ExcelDataRow) (rowAction, error)
+	}
+	ExcelModifyFilterRows struct {
+		RuleName        string
+		RowType         string
+		Any             bool
+		When            ExcelModifyCellConds
+		PromoteChildren bool
+		Keep            bool
+		pRowType        *regexp.Regexp
+	}
+	// ExcelModifyCellRule is a remove-cell, rename-tag or move-cell rule
+	ExcelModifyCellRule struct {
+		RuleName string
+		RowType  string
+		Any      bool
+		When     ExcelModifyCellConds
+		Cell     ExcelModifyCellConds
+		NewTag   string
+		NewCol   string
+		Kind     string
+		pRowType *regexp.Regexp
+	}
+	ExcelModifyAggregate struct {
+		RuleName  string
+		RowType   string
+		Any       bool
+		When      ExcelModifyCellConds
+		Child     string
+		Tag       string
+		Function  string
+		Separator string
+		Recursive bool
+		NewCell   ExcelModifyCell
+		pRowType  *regexp.Regexp
+		pChild    *regexp.Regexp
+		aggregate func(values []interface{}, separator string) (interface{}, error)
+	}
+)
+
+var aggregateFunctions = map[string]func(values []interface{}, separator string) (interface{}, error){
+	"sum": func(values []interface{}, separator string) (interface{}, error) {
+		numbers, ints, err := aggregateNumbers(values)
+		if err != nil {
+			return nil, err
+		}
+		sum := 0.0
+		for _, n := range numbers {
+			sum += n
+		}
+		return aggregateResult(sum, ints), nil
+	},
+	"count": func(values []interface{}, separator string) (interface{}, error) {
+		return len(values), nil
+	},
+	"min": func(values []interface{}, separator string) (interface{}, error) {
+		return aggregateCompare(values, -1)
+	},
+	"max": func(values []interface{}, separator string) (interface{}, error) {
+		return aggregateCompare(values, 1)
+	},
+	"avg": func(values []interface{}, separator string) (interface{}, error) {
+		numbers, _, err := aggregateNumbers(values)
+		if err != nil || len(numbers) == 0 {
+			return nil, err
+		}
+		sum := 0.0
+		for _, n := range numbers {
+			sum += n
+		}
+		return sum / float64(len(numbers)), nil
+	},
+	"first": func(values []interface{}, separator string) (interface{}, error) {
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values[0], nil
+	},
+	"last": func(values []interface{}, separator string) (interface{}, error) {
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values[len(values)-1], nil
+	},
+	"join": func(values []interface{}, separator string) (interface{}, error) {
+		parts := make([]string, 0, len(values))
+		for _, v := range values {
+			parts = append(parts, exprString(v))
+		}
+		return strings.Join(parts, separator), nil
+	},
+}
+
+// AddRule compiles the rule and registers it under its rule-name
+func (c *ExcelModifyConfiguration) AddRule(name string, rule ExcelModifyRule) error {
+	if _, ok := c.Rules[name]; ok {
+		return fmt.Errorf("rule %s is defined more than once", name)
+	}
+	if err := rule.Compile(); err != nil {
+		return fmt.Errorf("rule %s: %w", name, err)
+	}
+	c.Rules[name] = rule
+	return nil
+}
+
+func (rule *ExcelModifyFilterRows) Compile() error {
+	var err error
+	if rule.pRowType, err = regexp.Compile(rule.RowType); err != nil {
+		return err
+	}
+	return rule.When.Compile()
+}
+
+func (rule *ExcelModifyFilterRows) Match(row *ExcelDataRow) (bool, error) {
+	return rule.pRowType.MatchString(row.Name), nil
+}
+
+// Apply keeps the row for keep-rows when the conditions are met, for drop-rows otherwise
+func (rule *ExcelModifyFilterRows) Apply(row *ExcelDataRow) (rowAction, error) {
+	if ok, err := rule.When.Test(row.Cols, rule.Any); err != nil {
+		return rowActionKeep, err
+	} else if ok == rule.Keep {
+		return rowActionKeep, nil
+	} else if rule.PromoteChildren {
+		return rowActionPromote, nil
+	}
+	return rowActionDrop, nil
+}
+
+func (rule *ExcelModifyCellRule) Compile() error {
+	var err error
+	if rule.pRowType, err = regexp.Compile(rule.RowType); err != nil {
+		return err
+	}
+	if err := rule.When.Compile(); err != nil {
+		return err
+	}
+	if len(rule.Cell) == 0 {
+		return fmt.Errorf("%s requires a cell block", rule.Kind)
+	} else if err := rule.Cell.Compile(); err != nil {
+		return err
+	}
+	switch rule.Kind {
+	case "rename-tag":
+		if rule.NewTag == "" {
+			return fmt.Errorf("rename-tag requires new-tag")
+		}
+	case "move-cell":
+		if _, err := excelize.ColumnNameToNumber(rule.NewCol); err != nil {
+			return fmt.Errorf("move-cell requires a valid new-col: %w", err)
+		}
+	}
+	return nil
+}
+
+func (rule *ExcelModifyCellRule) Match(row *ExcelDataRow) (bool, error) {
+	if !rule.pRowType.MatchString(row.Name) {
+		return false, nil
+	}
+	return rule.When.Test(row.Cols, rule.Any)
+}
+
+func (rule *ExcelModifyCellRule) Apply(row *ExcelDataRow) (rowAction, error) {
+	cols := make(ExcelDataCols, 0, len(row.Cols))
+	for _, col := range row.Cols {
+		if ok, err := rule.Cell.Match(col); err != nil {
+			return rowActionKeep, err
+		} else if !ok {
+			cols = append(cols, col)
+			continue
+		}
+		// a copy keeps merge range and source of the cell
+		c := *col
+		switch rule.Kind {
+		case "rename-tag":
+			c.Tag = rule.NewTag
+			cols = append(cols, &c)
+		case "move-cell":
+			c.Col = rule.NewCol
+			cols = append(cols, &c)
+		}
+	}
+	row.Cols = cols
+	return rowActionKeep, nil
+}
+
+func (rule *ExcelModifyAggregate) Compile() error {
+	var err error
+	if rule.pRowType, err = regexp.Compile(rule.RowType); err != nil {
+		return err
+	}
+	if rule.pChild, err = regexp.Compile(rule.Child); err != nil {
+		return err
+	}
+	if rule.Tag == "" {
+		return fmt.Errorf("aggregate requires tag")
+	}
+	var ok bool
+	if rule.aggregate, ok = aggregateFunctions[rule.Function]; !ok {
+		return fmt.Errorf("unknown aggregate function %s", rule.Function)
+	}
+	return rule.When.Compile()
+}
+
+func (rule *ExcelModifyAggregate) Match(row *ExcelDataRow) (bool, error) {
+	if !rule.pRowType.MatchString(row.Name) {
+		return false, nil
+	}
+	return rule.When.Test(row.Cols, rule.Any)
+}
+
+func (rule *ExcelModifyAggregate) Apply(row *ExcelDataRow) (rowAction, error) {
+	values := rule.collect(row.Children, []interface{}{})
+	if value, err := rule.aggregate(values, rule.Separator); err != nil {
+		return rowActionKeep, fmt.Errorf("%s of %s: %w", rule.Function, rule.Tag, err)
+	} else {
+		row.Cols = append(row.Cols, &ExcelDataCol{
+			Col:   rule.NewCell.Col,
+			Tag:   rule.NewCell.Tag,
+			Value: value,
+		})
+	}
+	return rowActionKeep, nil
+}
+
+// collect gathers the non empty values of the tag in the matching child groups
+func (rule *ExcelModifyAggregate) collect(children ExcelDataChildren, values []interface{}) []interface{} {
+	for _, child := range children {
+		matches := rule.pChild.MatchString(child.Name)
+		for _, childRow := range child.Rows {
+			if matches {
+				for _, col := range childRow.Cols {
+					if col.Tag == rule.Tag && col.Value != nil && col.Value != "" {
+						values = append(values, col.Value)
+					}
+				}
+			}
+			if rule.Recursive {
+				values = rule.collect(childRow.Children, values)
+			}
+		}
+	}
+	return values
+}
+
+func aggregateNumbers(values []interface{}) ([]float64, bool, error) {
+	numbers := make([]float64, 0, len(values))
+	ints := true
+	for _, v := range values {
+		n, ok := exprNumber(v)
+		if !ok {
+			return nil, false, fmt.Errorf("%v is not a number", v)
+		}
+		if _, isInt := v.(int); !isInt {
+			ints = false
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, ints, nil
+}
+
+func aggregateResult(n float64, ints bool) interface{} {
+	if ints && n == math.Trunc(n) {
+		return int(n)
+	}
+	return n
+}
+
+// aggregateCompare returns the minimum (dir -1) or maximum (dir 1), numbers are compared
+// numerically, everything else as text
+func aggregateCompare(values []interface{}, dir int) (interface{}, error) {
+	var result interface{}
+	for _, v := range values {
+		if result == nil {
+			result = v
+		} else if exprCompare(">", v, result) == (dir > 0) && exprCompare("!=", v, result) {
+			result = v
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,296 @@
+package excel
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"sbl.systems/go/synwork/plugin-sdk/utils"
+)
+
+const (
+	sortOrderAsc  = "asc"
+	sortOrderDesc = "desc"
+
+	distinctKeepFirst = "first"
+	distinctKeepLast  = "last"
+)
+
+// classes of sort values, numbers are ordered before dates before text
+const (
+	sortClassEmpty = iota
+	sortClassNumber
+	sortClassDate
+	sortClassText
+)
+
+type (
+	ExcelModifySortKey struct {
+		Tag   string
+		Order string
+		Type  string
+	}
+	ExcelModifySortKeys []*ExcelModifySortKey
+	// sortValue is the value of a sort key of a row, classified once before sorting
+	sortValue struct {
+		class  int
+		number float64
+		date   time.Time
+		text   string
+	}
+	ExcelModifyDistinct struct {
+		Tags string
+		Keep string
+	}
+	ExcelModifyGroupBy struct {
+		Tags    string
+		RowType string
+	}
+)
+
+// excel_modify_rows_sheet_operations runs distinct, sort and group-by of the sheet block
+// in this order on the rows of the sheet
+func excel_modify_rows_sheet_operations(sheet *ExcelModifySheet, rows ExcelDataRows) (ExcelDataRows, error) {
+	var err error
+	if sheet.Distinct != nil {
+		if rows, err = sheet.Distinct.Apply(rows); err != nil {
+			return nil, fmt.Errorf("distinct: %w", err)
+		}
+	}
+	if len(sheet.Sort) > 0 {
+		if rows, err = sheet.Sort.Apply(rows); err != nil {
+			return nil, fmt.Errorf("sort: %w", err)
+		}
+	}
+	if sheet.GroupBy != nil {
+		if rows, err = sheet.GroupBy.Apply(rows); err != nil {
+			return nil, fmt.Errorf("group-by: %w", err)
+		}
+	}
+	return rows, nil
+}
+
+// Compile checks order and type of the sort keys
+func (keys ExcelModifySortKeys) Compile() error {
+	for idx, key := range keys {
+		switch key.Order {
+		case sortOrderAsc, sortOrderDesc:
+		default:
+			return fmt.Errorf("sort[%d]: unknown order %s for tag %s", idx, key.Order, key.Tag)
+		}
+		switch key.Type {
+		case cellTypeAuto, cellTypeString, cellTypeNumber, cellTypeDate:
+		default:
+			return fmt.Errorf("sort[%d]: unknown type %s for tag %s", idx, key.Type, key.Tag)
+		}
+	}
+	return nil
+}
+
+// Apply sorts the rows stable by the keys, rows without a value come first. The values are
+// classified once per row, so mixed columns are ordered numbers, dates, text.
+func (keys ExcelModifySortKeys) Apply(rows ExcelDataRows) (ExcelDataRows, error) {
+	type sortRow struct {
+		row    *ExcelDataRow
+		values []sortValue
+	}
+	sortRows := make([]sortRow, len(rows))
+	for idx, row := range rows {
+		sortRows[idx] = sortRow{row: row, values: make([]sortValue, len(keys))}
+		for k, key := range keys {
+			sortRows[idx].values[k] = key.Value(rowTagValue(row, key.Tag))
+		}
+	}
+	sort.SliceStable(sortRows, func(i, j int) bool {
+		for k, key := range keys {
+			cmp := sortRows[i].values[k].Compare(sortRows[j].values[k])
+			if key.Order == sortOrderDesc {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	sorted := make(ExcelDataRows, len(sortRows))
+	for idx, sortRow := range sortRows {
+		sorted[idx] = sortRow.row
+	}
+	return sorted, nil
+}
+
+// Value classifies a value by the type of the key, auto tries number, date and text in
+// this order, values not matching number or date are text
+func (key *ExcelModifySortKey) Value(v interface{}) sortValue {
+	if v == nil || v == "" {
+		return sortValue{class: sortClassEmpty}
+	}
+	if key.Type != cellTypeString && key.Type != cellTypeDate {
+		if n, ok := exprNumber(v); ok {
+			return sortValue{class: sortClassNumber, number: n}
+		}
+	}
+	if key.Type != cellTypeString && key.Type != cellTypeNumber {
+		if t, ok := sortDate(v); ok {
+			return sortValue{class: sortClassDate, date: t}
+		}
+	}
+	return sortValue{class: sortClassText, text: exprString(v)}
+}
+
+// Compare orders the values by class first and by value within the class
+func (s sortValue) Compare(o sortValue) int {
+	if s.class != o.class {
+		return intCompare(s.class, o.class)
+	}
+	switch s.class {
+	case sortClassNumber:
+		return floatCompare(s.number, o.number)
+	case sortClassDate:
+		switch {
+		case s.date.Before(o.date):
+			return -1
+		case s.date.After(o.date):
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(s.text, o.text)
+}
+
+func sortDate(v interface{}) (time.Time, bool) {
+	if _, ok := v.(string); !ok {
+		return time.Time{}, false
+	}
+	iso, err := exprParseDate([]interface{}{v})
+	if err != nil || iso == nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, iso.(string))
+	return t, err == nil
+}
+
+func floatCompare(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func intCompare(x, y int) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// Apply removes the rows with the same values of the tags, the kept rows stay in file order
+func (d *ExcelModifyDistinct) Apply(rows ExcelDataRows) (ExcelDataRows, error) {
+	tags := sheetOperationTags(d.Tags)
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("tags required")
+	}
+	keep := map[string]int{}
+	for idx, row := range rows {
+		key := rowTagKey(row, tags)
+		switch d.Keep {
+		case distinctKeepFirst:
+			if _, ok := keep[key]; !ok {
+				keep[key] = idx
+			}
+		case distinctKeepLast:
+			keep[key] = idx
+		default:
+			return nil, fmt.Errorf("unknown keep %s", d.Keep)
+		}
+	}
+	result := ExcelDataRows{}
+	for idx, row := range rows {
+		if keep[rowTagKey(row, tags)] == idx {
+			result = append(result, row)
+		}
+	}
+	return result, nil
+}
+
+// Apply groups the rows with the same values of the tags below a new parent row of row-type
+// with the cells of the tags. The children are grouped by their row type like the reader does,
+// the groups keep the order of their first row.
+func (g *ExcelModifyGroupBy) Apply(rows ExcelDataRows) (ExcelDataRows, error) {
+	tags := sheetOperationTags(g.Tags)
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("tags required")
+	}
+	result := ExcelDataRows{}
+	groups := map[string]*ExcelDataRow{}
+	for _, row := range rows {
+		key := rowTagKey(row, tags)
+		parent, ok := groups[key]
+		if !ok {
+			parent = &ExcelDataRow{
+				Name:     g.RowType,
+				Index:    row.Index,
+				Cols:     make(ExcelDataCols, 0),
+				Children: make(ExcelDataChildren, 0),
+			}
+			for _, tag := range tags {
+				for _, col := range row.Cols {
+					if col.Tag == tag {
+						parent.Cols = append(parent.Cols, &ExcelDataCol{Col: col.Col, Tag: col.Tag, Value: col.Value})
+						break
+					}
+				}
+			}
+			groups[key] = parent
+			result = append(result, parent)
+		}
+		var child *ExcelDataChild
+		for _, c := range parent.Children {
+			if c.Name == row.Name {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			child = &ExcelDataChild{Name: row.Name}
+			parent.Children = append(parent.Children, child)
+		}
+		child.Rows = append(child.Rows, row)
+	}
+	return result, nil
+}
+
+func sheetOperationTags(tags string) []string {
+	result := []string{}
+	for _, tag := range utils.MapArray[string, string](strings.Split(tags, ","), []string{}, strings.TrimSpace) {
+		if tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+func rowTagValue(row *ExcelDataRow, tag string) interface{} {
+	for _, col := range row.Cols {
+		if col.Tag == tag {
+			return col.Value
+		}
+	}
+	return nil
+}
+
+func rowTagKey(row *ExcelDataRow, tags []string) string {
+	parts := make([]string, len(tags))
+	for idx, tag := range tags {
+		parts[idx] = exprString(rowTagValue(row, tag))
+	}
+	return strings.Join(parts, "\x00")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 
 	excelize "github.com/xuri/excelize/v2"
@@ -13,38 +14,53 @@ import (
 
 // https://xuri.me/excelize/en/cell.html#SetCellStyle
 var (
+	excel_read_row_cond = _excel_condition(map[string]*schema.Schema{
+		"col": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+	})
 	excel_file_read = map[string]*schema.Schema{
 		"file-name": {Type: schema.TypeString, Required: true, DefaultValue: "file1.xlsx"},
+		"output":    {Type: schema.TypeString, Optional: true, DefaultValue: readOutputCols},
+		// field names of the row name, index and children in records
+		"name-field":  {Type: schema.TypeString, Optional: true, DefaultValue: "_name"},
+		"index-field": {Type: schema.TypeString, Optional: true, DefaultValue: "_index"},
+		"child-field": {Type: schema.TypeString, Optional: true, DefaultValue: "_child"},
 		"sheet": {
 			Type: schema.TypeList,
 			Elem: map[string]*schema.Schema{
-				"when": {
-					Type: schema.TypeList,
+				"when":        {Type: schema.TypeList, Elem: _excel_sheet_selector()},
+				"row":         {Type: schema.TypeString, Required: true, DefaultValue: ""},
+				"start-row":   {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
+				"end-row":     {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
+				"skip-rows":   {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+				"merged":      {Type: schema.TypeString, Optional: true, DefaultValue: readMergedKeep},
+				"merge-range": {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+				"stop-when":   {Type: schema.TypeList, Elem: excel_read_row_cond},
+				"header": {
+					Type:     schema.TypeMap,
+					Optional: true,
 					Elem: map[string]*schema.Schema{
-						"pattern": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
-						"low":     {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
-						"high":    {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
+						"row":  {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
+						"when": {Type: schema.TypeList, Elem: excel_read_row_cond},
 					},
 				},
-				"row": {Type: schema.TypeString, Required: true, DefaultValue: ""},
 			},
 		},
 		"row": {
 			Type: schema.TypeList,
 			Elem: map[string]*schema.Schema{
 				"name": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
-				"when": {
-					Type: schema.TypeList,
-					Elem: map[string]*schema.Schema{
-						"col":     {Type: schema.TypeString, Optional: true, DefaultValue: ""},
-						"pattern": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
-					},
-				},
+				"when": {Type: schema.TypeList, Elem: excel_read_row_cond},
 				"cell": {
 					Type: schema.TypeList,
 					Elem: map[string]*schema.Schema{
-						"col": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
-						"tag": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+						"col":            {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+						"cols":           {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+						"tag":            {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+						"type":           {Type: schema.TypeString, Optional: true, DefaultValue: cellTypeAuto},
+						"header":         {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+						"header-pattern": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+						"required":       {Type: schema.TypeBool, Optional: true, DefaultValue: true},
+						"source":         {Type: schema.TypeString, Optional: true, DefaultValue: readSourceCached},
 					},
 				},
 				"children": {Type: schema.TypeString, Required: true, DefaultValue: ""},
@@ -59,30 +75,63 @@ var Method_read_file = &schema.Method{
 		"sheets": {
 			Type: schema.TypeList,
 			Elem: map[string]*schema.Schema{
-				"name":  {Type: schema.TypeString, Required: true},
-				"index": {Type: schema.TypeInt, Required: true},
+				"name":   {Type: schema.TypeString, Required: true},
+				"index":  {Type: schema.TypeInt, Required: true},
+				"hidden": {Type: schema.TypeBool, Optional: true},
 				"rows": {
 					Type: schema.TypeList,
 					Elem: _excel_row_element(),
 				},
 			},
 		},
+		"records": {
+			Type: schema.TypeList,
+			Elem: map[string]*schema.Schema{
+				"name":   {Type: schema.TypeString, Required: true},
+				"index":  {Type: schema.TypeInt, Required: true},
+				"hidden": {Type: schema.TypeBool, Optional: true},
+				// list of maps tag -> value with name-field, index-field and child-field of the row
+				"rows": {Type: schema.TypeGeneric},
+			},
+		},
 	},
 	ExecFunc: excel_read_file,
 	Description: `Method read_excel_file provides a way to read excel file based on a configuration.
 
 	method "read_excel_file" "processor-instance" "method-instance" {
 		file-name = "test01.xlsx"
+		output = "cols" // or records
 		sheet {
 			when {
-				name = "pattern"
+				name = "pattern" // regular expression, or exact = "Sheet 1"
 			}
 			when {
-				low = 1
-				high = 2
+				low = 1 // 1-based index of the sheet
+				high = 2 // 0 up to the last sheet
+				skip-hidden = true
+			}
+			when {
+				not = true // the block must not match
+				any { // one of the any blocks has to match
+					name = "^Notes"
+				}
+				any {
+					exact = "Summary"
+				}
 			}
 			row = "standard"
-
+			header {
+				row = 1 // or when blocks like in row
+			}
+			start-row = 3 // first row read, 0 from the beginning
+			end-row = 0 // last row read, 0 up to the end
+			skip-rows = "5,8-10" // rows and row ranges not read
+			merged = "fill" // keep: only the top-left cell of a merged range has the value
+			merge-range = true // adds the merged range like "A2:A11" as merge to the cols
+			stop-when { // reading of the sheet ends before the first row matching all stop-when
+				col = "A"
+				pattern = "^Total"
+			}
 		}
 
 		row {
@@ -91,9 +140,34 @@ var Method_read_file = &schema.Method{
 				col = "A"
 				pattern = "^AD$" // regulare expression
 			}
+			when {
+				any { // one of the any blocks has to match
+					col = "C"
+					gt = 100 // lt = 0, between = [1, 10]
+				}
+				any {
+					col = "D"
+					empty = true
+				}
+			}
 			cell {
 				col = "A"
 				tag = "lineType"
+				type = "auto" // auto, string, number, int, bool, date
+			}
+			cell {
+				header = "Amount" // or header-pattern = "^Amount( EUR)?$"
+				tag = "amount"
+				required = true
+			}
+			cell {
+				col = "F"
+				tag = "total"
+				source = "calculated" // cached, formula or calculated
+			}
+			cell {
+				cols = "D:*" // or "D:O", one col for every non empty column
+				tag = "month_{index}" // {index} 1-based in the range, {col} letter, {header} text
 			}
 			children = "rowType1,rowType2"
 		}
@@ -130,43 +204,129 @@ var Method_read_file = &schema.Method{
 
 	]
 
+	A sheet is read with every sheet block whose when blocks all match, hidden sheets are
+	marked with hidden = true in the result. The when attribute pattern is deprecated, it is
+	the older name of name and will be removed.
+
+	With merged = "fill" every cell covered by a merged range of the sheet has the value of
+	the top-left cell of the range, for the when blocks and for the cells.
+
+	A when block matches when the cell of col passes all of its tests and, with any blocks,
+	one of the any blocks matches. The tests are pattern, not-pattern (regular expressions
+	on the text of the cell), empty, not-empty, gt, lt, between, is-number, is-date and
+	equals, which use the value of the cell as type auto reads it. A cell beyond the end of
+	the row only matches empty. stop-when and header when blocks have the same tests.
+
+	source of a cell chooses what is read from cells with a formula: cached is the value
+	stored in the file, formula the formula text without leading = and calculated the value
+	calculated from the formula, which works for files saved without values too. Cells
+	without formula always return the cached value. Every col reports the source of its
+	value as source.
+
+	A cell with cols reads a range of columns, without tag the tag is the header text of the
+	column if the sheet has a header, otherwise the column letter.
+
+	The configuration is validated before the file is opened, all problems are reported
+	at once with their config path like row["standard"].when[0].pattern.
+
+	With output = "records" the result is records instead of sheets, every row is a map of
+	its tags to their values. name-field, index-field and child-field (default _name, _index
+	and _child like flatten_rows) hold the name, the index and the children of the row, an
+	empty field name omits the field. A tag with the name of one of the fields is an error.
+
+	records:[
+		{
+			name : "sheet01",
+			index : 1,
+			rows : [
+				{
+					_index : 1,
+					_name : "standard",
+					tag01 : "",
+					_child : [
+						{
+							name : "child01",
+							rows : []
+						}
+					]
+				}
+			]
+		}
+	]
+
 	`,
 }
 
+const (
+	readOutputCols    = "cols"
+	readOutputRecords = "records"
+)
+
 type (
+	// ExcelReadRecordFields are the field names of the row name, index and children in records
+	ExcelReadRecordFields struct {
+		Name  string
+		Index string
+		Child string
+	}
 	ExcelReadSheet struct {
-		Conditions ExcelReadSheetConditions
+		Conditions ExcelSheetSelectors
 		Row        string
+		Header     *ExcelReadSheetHeader
+		StartRow   int
+		EndRow     int
+		SkipRows   string
+		StopWhen   ExcelReadRowConditions
+		Merged     string
+		MergeRange bool
+		skipRanges []readRowRange
 	}
-	ExcelReadSheetCondition interface {
-		Test(name string, index int) (bool, error)
-	}
-	ExcelReadSheetCondPattern struct {
-		Pattern string
-	}
-	ExcelReadSheetCondRange struct {
+	readRowRange struct {
 		Low, High int
 	}
-	ExcelReadSheetConditions []ExcelReadSheetCondition
-	ExcelReadRow             struct {
+	ExcelReadSheetHeader struct {
+		Row        int
+		Conditions ExcelReadRowConditions
+	}
+	ExcelReadRow struct {
 		Name       string
 		Children   []string
 		Conditions ExcelReadRowConditions
 		Cells      ExcelReadRowCells
 	}
 	ExcelReadRowCondition struct {
-		Col     string
-		Pattern string
+		Col string
+		conditionSpec
+		Any    ExcelReadRowConditions
+		colIdx int
+		tests  *conditionTests
 	}
 	ExcelReadRowConditions []*ExcelReadRowCondition
 	ExcelReadRowCell       struct {
-		Col string
-		Tag string
+		Col           string
+		Tag           string
+		Type          string
+		Header        string
+		HeaderPattern string
+		Required      bool
+		Cols          string
+		Source        string
+		headerRegexp  *regexp.Regexp
+		colIdx        int
+		colsRange     readColRange
+	}
+	// readColRange is a range of column numbers, High 0 is up to the last column
+	readColRange struct {
+		Low, High int
 	}
 	ExcelReadRowCells   []*ExcelReadRowCell
 	ExcelReadRepository struct {
-		Sheets []*ExcelReadSheet
-		Rows   map[string]*ExcelReadRow
+		Sheets   []*ExcelReadSheet
+		Rows     map[string]*ExcelReadRow
+		rowNames []string
+		// lookupCells reads the cell metadata with the cell lookups of excelize instead of
+		// streaming it, only to compare both ways
+		lookupCells bool
 	}
 	readStack struct {
 		parent     *readStack
@@ -176,11 +336,17 @@ type (
 		currentRow *ExcelDataRow
 		allRows    ExcelDataRows
 	}
+	readSheetHeader struct {
+		Row   int
+		Names []string
+		cols  map[*ExcelReadRowCell]string
+	}
 
 	ExcelDataSheet struct {
-		Name  string        `json:"name"`
-		Index int           `json:"index"`
-		Rows  ExcelDataRows `json:"rows"`
+		Name   string        `json:"name"`
+		Index  int           `json:"index"`
+		Hidden bool          `json:"hidden"`
+		Rows   ExcelDataRows `json:"rows"`
 	}
 	ExcelDataSheets []*ExcelDataSheet
 	ExcelDataRows   []*ExcelDataRow
@@ -192,9 +358,11 @@ type (
 	}
 	ExcelDataCols []*ExcelDataCol
 	ExcelDataCol  struct {
-		Col   string      `json:"col"`
-		Tag   string      `json:"tag"`
-		Value interface{} `json:"value"`
+		Col    string      `json:"col"`
+		Tag    string      `json:"tag"`
+		Value  interface{} `json:"value"`
+		Merge  string      `json:"merge,omitempty"`
+		Source string      `json:"source,omitempty"`
 	}
 	ExcelDataChildren []*ExcelDataChild
 	ExcelDataChild    struct {
@@ -203,78 +371,303 @@ type (
 	}
 )
 
-func (c ExcelReadRowConditions) Test(values []string) (bool, error) {
+// Test combines the conditions with AND
+func (c ExcelReadRowConditions) Test(src *readRowSource) (bool, error) {
 	for _, cond := range c {
-		if idx, err := excelize.ColumnNameToNumber(cond.Col); err != nil {
+		if ok, err := cond.Test(src); err != nil || !ok {
 			return false, err
-		} else if idx-1 >= len(values) {
-			return false, nil
-		} else if expr, err := regexp.Compile(cond.Pattern); err != nil {
-			return false, err
-		} else if !expr.MatchString(values[idx-1]) {
-			return false, nil
 		}
 	}
 	return true, nil
 }
 
-func (c ExcelReadRowCells) Apply(values []string) (ExcelDataCols, error) {
+// Test checks the cell of col and whether one of the any conditions matches, a cell
+// beyond the end of the row only matches empty
+func (cond *ExcelReadRowCondition) Test(src *readRowSource) (bool, error) {
+	if err := cond.Compile(); err != nil {
+		return false, err
+	}
+	if cond.Col != "" {
+		if cond.colIdx-1 >= len(src.values) {
+			if !cond.tests.empty {
+				return false, nil
+			}
+		} else if ok, err := cond.tests.Match(src.values[cond.colIdx-1], func() (interface{}, error) {
+			v, err := src.Cell(cond.Col, cond.colIdx)
+			if err != nil {
+				return nil, err
+			}
+			return cellTypeConverters[cellTypeAuto](v)
+		}); err != nil || !ok {
+			return false, err
+		}
+	}
+	if len(cond.Any) == 0 {
+		return true, nil
+	}
+	for _, alternative := range cond.Any {
+		if ok, err := alternative.Test(src); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// Compile resolves the column and compiles the tests once
+func (cond *ExcelReadRowCondition) Compile() error {
+	if cond.tests != nil {
+		return nil
+	}
+	if cond.Col == "" && len(cond.Any) == 0 {
+		return fmt.Errorf("condition requires col or any")
+	} else if cond.Col != "" {
+		idx, err := excelize.ColumnNameToNumber(cond.Col)
+		if err != nil {
+			return err
+		}
+		cond.colIdx = idx
+	}
+	tests, err := cond.conditionSpec.Compile()
+	if err != nil {
+		return err
+	}
+	cond.tests = tests
+	return nil
+}
+
+// Typed checks whether a condition needs the typed value of its cell
+func (c ExcelReadRowConditions) Typed() bool {
+	for _, cond := range c {
+		if cond.tests != nil && cond.tests.Typed() || cond.Any.Typed() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c ExcelReadRowCells) Apply(src *readRowSource) (ExcelDataCols, error) {
 	cols := make(ExcelDataCols, 0)
 	for _, col := range c {
-		if idx, err := excelize.ColumnNameToNumber(col.Col); err != nil {
+		if col.Cols != "" {
+			rangeCols, err := col.ApplyRange(src)
+			if err != nil {
+				return nil, err
+			}
+			cols = append(cols, rangeCols...)
+			continue
+		}
+		column, idx := col.Col, col.colIdx
+		if col.ByHeader() {
+			if src.header == nil {
+				return nil, fmt.Errorf("sheet %s: cell %s uses a header, but no header is defined for the sheet", src.sheet, col.Tag)
+			} else if column = src.header.cols[col]; column == "" {
+				continue
+			}
+			idx = 0
+		}
+		if idx == 0 {
+			var err error
+			if idx, err = excelize.ColumnNameToNumber(column); err != nil {
+				return nil, err
+			}
+		}
+		if idx-1 >= len(src.values) {
+			// behind the last non empty column, keep the col like an empty cell
+			cols = append(cols, &ExcelDataCol{
+				Col:    column,
+				Tag:    col.Tag,
+				Value:  nil,
+				Merge:  src.MergeRef(idx),
+				Source: readSourceCached,
+			})
+		} else if value, source, err := col.Content(src, column, idx); err != nil {
 			return nil, err
-		} else if idx-1 < len(values) {
+		} else {
 			cols = append(cols, &ExcelDataCol{
-				Col:   col.Col,
-				Tag:   col.Tag,
-				Value: values[idx-1],
+				Col:    column,
+				Tag:    col.Tag,
+				Value:  value,
+				Merge:  src.MergeRef(idx),
+				Source: source,
 			})
 		}
 	}
 	return cols, nil
 }
 
-func (c *ExcelReadSheetCondPattern) Test(name string, index int) (bool, error) {
-	pattern, err := regexp.Compile(c.Pattern)
-	if err != nil {
-		return false, err
+// ApplyRange reads the non empty columns of the cols range
+func (c *ExcelReadRowCell) ApplyRange(src *readRowSource) (ExcelDataCols, error) {
+	cols := make(ExcelDataCols, 0)
+	high := c.colsRange.High
+	if high == 0 || high > len(src.values) {
+		high = len(src.values)
+	}
+	for idx := c.colsRange.Low; idx <= high; idx++ {
+		column, err := excelize.ColumnNumberToName(idx)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(src.values[idx-1]) == "" {
+			// formulas without cached value are read anyway
+			if c.Source != readSourceFormula && c.Source != readSourceCalculated {
+				continue
+			} else if formula, err := src.Formula(column, idx); err != nil {
+				return nil, err
+			} else if formula == "" {
+				continue
+			}
+		}
+		value, source, err := c.Content(src, column, idx)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, &ExcelDataCol{
+			Col:    column,
+			Tag:    c.RangeTag(src, column, idx),
+			Value:  value,
+			Merge:  src.MergeRef(idx),
+			Source: source,
+		})
+	}
+	return cols, nil
+}
+
+// RangeTag returns the tag of a column of the cols range
+func (c *ExcelReadRowCell) RangeTag(src *readRowSource, column string, idx int) string {
+	header := ""
+	if src.header != nil && idx-1 < len(src.header.Names) {
+		header = src.header.Names[idx-1]
+	}
+	if c.Tag == "" {
+		if header != "" {
+			return header
+		}
+		return column
+	}
+	return strings.NewReplacer(
+		"{index}", strconv.Itoa(idx-c.colsRange.Low+1),
+		"{col}", column,
+		"{header}", header,
+	).Replace(c.Tag)
+}
+
+func (c *ExcelReadRowCell) ByHeader() bool {
+	return c.Header != "" || c.HeaderPattern != ""
+}
+
+// MatchHeader checks a header text against header or header-pattern of the cell
+func (c *ExcelReadRowCell) MatchHeader(name string) bool {
+	if c.headerRegexp != nil {
+		return c.headerRegexp.MatchString(name)
 	}
-	return pattern.MatchString(name), nil
+	return strings.TrimSpace(name) == strings.TrimSpace(c.Header)
 }
 
-func (c *ExcelReadSheetCondRange) Test(name string, index int) (bool, error) {
-	return c.Low <= index && index <= c.High, nil
+// Resolve maps every header based cell of the reachable row definitions to its column
+func (h *readSheetHeader) Resolve(sheetName string, repository *ExcelReadRepository, rowName string) error {
+	visited := map[string]bool{}
+	var resolveRow func(name string) error
+	resolveRow = func(name string) error {
+		row, ok := repository.Rows[name]
+		if !ok || visited[name] {
+			return nil
+		}
+		visited[name] = true
+		for _, cell := range row.Cells {
+			if !cell.ByHeader() {
+				continue
+			}
+			for idx, headerName := range h.Names {
+				if cell.MatchHeader(headerName) {
+					h.cols[cell], _ = excelize.ColumnNumberToName(idx + 1)
+					break
+				}
+			}
+			if _, ok := h.cols[cell]; !ok && cell.Required {
+				return fmt.Errorf("sheet %s: required header %q not found in row %d, found headers: %s",
+					sheetName, cell.Header+cell.HeaderPattern, h.Row, strings.Join(h.Names, ", "))
+			}
+		}
+		for _, child := range row.Children {
+			if err := resolveRow(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return resolveRow(rowName)
 }
 
 func excel_read_file(ctx context.Context, data *schema.MethodData, client interface{}) error {
 	fileName := data.GetConfig("file-name").(string)
+	output, _ := data.GetConfig("output").(string)
+	if output != "" && output != readOutputCols && output != readOutputRecords {
+		return fmt.Errorf("unknown output %s, expected %s or %s", output, readOutputCols, readOutputRecords)
+	}
 	repository, err := excel_read_file_configure(ctx, data)
 	if err != nil {
 		return err
 	}
-	f, err := excelize.OpenFile(fileName)
+	if err := repository.Validate(); err != nil {
+		return err
+	}
+	resultSheets, err := excel_read_sheets(fileName, repository)
 	if err != nil {
 		return err
 	}
+	if output == readOutputRecords {
+		fields := &ExcelReadRecordFields{}
+		fields.Name, _ = data.GetConfig("name-field").(string)
+		fields.Index, _ = data.GetConfig("index-field").(string)
+		fields.Child, _ = data.GetConfig("child-field").(string)
+		records := []interface{}{}
+		for _, item := range resultSheets {
+			if record, err := item.Records(fields); err != nil {
+				return err
+			} else {
+				records = append(records, record)
+			}
+		}
+		data.SetResult("records", records)
+		return nil
+	}
+	sheets := []interface{}{}
+	for _, item := range resultSheets {
+		enc := utils.NewEncoder()
+		if encItem, err := enc.Encode(item); err != nil {
+			return err
+		} else {
+			sheets = append(sheets, encItem)
+		}
+	}
+	data.SetResult("sheets", sheets)
+	return nil
+}
+
+// excel_read_sheets reads the rows of all sheets matching a sheet definition of the repository
+func excel_read_sheets(fileName string, repository *ExcelReadRepository) (ExcelDataSheets, error) {
+	f, err := excelize.OpenFile(fileName)
+	if err != nil {
+		return nil, err
+	}
 	resultSheets := make(ExcelDataSheets, 0)
 	for sheetIdx := 0; sheetIdx < f.SheetCount; sheetIdx++ {
 		sheetName := f.GetSheetName(sheetIdx)
+		hidden := !f.GetSheetVisible(sheetName)
 		cfgSheetIdx := 0
-	select_cfg_sheet:
 		for cfgSheetIdx < len(repository.Sheets) {
 			cfgSheet := repository.Sheets[cfgSheetIdx]
-			for _, cfgSheetCond := range cfgSheet.Conditions {
-				if ok, err := cfgSheetCond.Test(sheetName, sheetIdx+1); err != nil {
-					return err
-				} else if !ok {
-					cfgSheetIdx++
-					goto select_cfg_sheet
-				}
+			if ok, err := cfgSheet.Conditions.Test(sheetName, sheetIdx+1, hidden); err != nil {
+				return nil, err
+			} else if !ok {
+				cfgSheetIdx++
+				continue
 			}
 			resultSheet := &ExcelDataSheet{
-				Name:  sheetName,
-				Index: sheetIdx + 1,
-				Rows:  make(ExcelDataRows, 0),
+				Name:   sheetName,
+				Index:  sheetIdx + 1,
+				Hidden: hidden,
+				Rows:   make(ExcelDataRows, 0),
 			}
 			sheetTop := &readStack{
 				sheet:      cfgSheet,
@@ -282,20 +675,77 @@ func excel_read_file(ctx context.Context, data *schema.MethodData, client interf
 				row:        repository.Rows[cfgSheet.Row],
 			}
 			stack := sheetTop
-			rows, _ := f.Rows(sheetName)
+			var header *readSheetHeader
+			date1904 := f.WorkBook != nil && f.WorkBook.WorkbookPr != nil && f.WorkBook.WorkbookPr.Date1904
+			// the merged ranges are looked up before the rows iterator reads the sheet
+			var merges *readMerges
+			if cfgSheet.Merged == readMergedFill || cfgSheet.MergeRange {
+				if merges, err = excel_read_merges(f, sheetName, date1904, cfgSheet.Merged == readMergedFill && repository.NeedsCellMeta()); err != nil {
+					return nil, err
+				}
+			}
+			rows, err := f.Rows(sheetName)
+			if err != nil {
+				return nil, err
+			}
+			// the metadata of the cells is streamed next to the rows, looking up every cell in
+			// the file is slow for large sheets
+			var stream *readSheetStream
+			if repository.NeedsCellMeta() && !repository.lookupCells {
+				if stream, err = excel_read_stream_open(fileName, sheetName); err != nil {
+					return nil, excel_read_close(err, rows, nil)
+				}
+			}
 			rowIdx := 0
 			for rows.Next() {
 				rowIdx++
-				cells, _ := rows.Columns()
+				cells, err := rows.Columns()
+				if err != nil {
+					return nil, excel_read_close(err, rows, stream)
+				}
+				src := &readRowSource{file: f, sheet: sheetName, row: rowIdx, header: header, date1904: date1904}
+				if merges != nil {
+					src.merged, src.fill, src.mergeRange = merges.Row(rowIdx), cfgSheet.Merged == readMergedFill, cfgSheet.MergeRange
+					if src.fill {
+						cells = readMergeFill(rowIdx, src.merged, cells)
+					}
+				}
+				src.values = cells
+				if stream != nil {
+					if src.meta, err = stream.Row(rowIdx); err != nil {
+						return nil, excel_read_close(err, rows, stream)
+					}
+					src.stream = stream
+				}
+				if cfgSheet.Header != nil && header == nil {
+					// rows up to the header row are skipped
+					if header, err = excel_read_file_header(cfgSheet.Header, src); err != nil {
+						return nil, excel_read_close(err, rows, stream)
+					} else if header != nil {
+						if err := header.Resolve(sheetName, repository, cfgSheet.Row); err != nil {
+							return nil, excel_read_close(err, rows, stream)
+						}
+					}
+					continue
+				}
+				if cfgSheet.EndRow > 0 && rowIdx > cfgSheet.EndRow {
+					break
+				} else if cfgSheet.Skip(rowIdx) {
+					continue
+				} else if stop, err := cfgSheet.Stop(src); err != nil {
+					return nil, excel_read_close(err, rows, stream)
+				} else if stop {
+					break
+				}
 			read_cells:
 				if stack.row == nil {
-					return fmt.Errorf("there is no definition for %s used in sheet %s", cfgSheet.Row, sheetName)
+					return nil, excel_read_close(fmt.Errorf("there is no definition for %s used in sheet %s", cfgSheet.Row, sheetName), rows, stream)
 				}
-				if ok, err := stack.row.Conditions.Test(cells); err != nil {
-					return err
+				if ok, err := stack.row.Conditions.Test(src); err != nil {
+					return nil, excel_read_close(err, rows, stream)
 				} else if ok {
-					if eCells, err := stack.row.Cells.Apply(cells); err != nil {
-						return err
+					if eCells, err := stack.row.Cells.Apply(src); err != nil {
+						return nil, excel_read_close(err, rows, stream)
 					} else {
 						stack.currentRow = &ExcelDataRow{
 							Name:     stack.row.Name,
@@ -304,6 +754,8 @@ func excel_read_file(ctx context.Context, data *schema.MethodData, client interf
 							Children: make(ExcelDataChildren, 0),
 						}
 						stack.allRows = append(stack.allRows, stack.currentRow)
+						// a new parent row starts again with its first child definition
+						stack.childIndex = -1
 					}
 				} else if stack.currentRow != nil && stack.childIndex+1 < len(stack.row.Children) {
 					stack.childIndex++
@@ -315,34 +767,154 @@ func excel_read_file(ctx context.Context, data *schema.MethodData, client interf
 					stack = child
 					goto read_cells
 				} else if stack.parent != nil {
-					if stack.currentRow != nil {
-						stack.parent.currentRow.Children = append(stack.parent.currentRow.Children, &ExcelDataChild{
-							Name: stack.currentRow.Name,
-							Rows: stack.allRows,
-						})
-					}
-					stack = stack.parent
+					stack = stack.Pop()
 					goto read_cells
 				}
 			}
+			if err := excel_read_close(rows.Error(), rows, stream); err != nil {
+				return nil, err
+			} else if cfgSheet.Header != nil && header == nil {
+				return nil, fmt.Errorf("sheet %s: header row not found", sheetName)
+			}
+			// rows of open children at the end of the sheet belong to their parents too
+			for stack.parent != nil {
+				stack = stack.Pop()
+			}
 			cfgSheetIdx++
-			resultSheet.Rows = stack.allRows
+			resultSheet.Rows = sheetTop.allRows
 			resultSheets = append(resultSheets, resultSheet)
 		}
 
 	}
+	return resultSheets, nil
+}
 
-	sheets := []interface{}{}
-	for _, item := range resultSheets {
-		enc := utils.NewEncoder()
-		if encItem, err := enc.Encode(item); err != nil {
-			return err
+// Records returns the sheet with its rows as maps of tag -> value
+func (s *ExcelDataSheet) Records(fields *ExcelReadRecordFields) (map[string]interface{}, error) {
+	rows, err := s.Rows.Records(fields)
+	if err != nil {
+		return nil, fmt.Errorf("sheet %s: %w", s.Name, err)
+	}
+	return map[string]interface{}{
+		"name":   s.Name,
+		"index":  s.Index,
+		"hidden": s.Hidden,
+		"rows":   rows,
+	}, nil
+}
+
+func (rows ExcelDataRows) Records(fields *ExcelReadRecordFields) ([]interface{}, error) {
+	records := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if record, err := row.Record(fields); err != nil {
+			return nil, err
 		} else {
-			sheets = append(sheets, encItem)
+			records = append(records, record)
 		}
 	}
-	data.SetResult("sheets", sheets)
-	return nil
+	return records, nil
+}
+
+// Record returns the tags of the row with their values and the name, index and children
+// of the row in the fields, a tag named like a field is an error and cells without tag
+// are left out
+func (r *ExcelDataRow) Record(fields *ExcelReadRecordFields) (map[string]interface{}, error) {
+	record := make(map[string]interface{}, len(r.Cols)+3)
+	for _, col := range r.Cols {
+		if col.Tag == "" {
+			continue
+		} else if col.Tag == fields.Name || col.Tag == fields.Index || col.Tag == fields.Child {
+			return nil, fmt.Errorf("row %d: tag %s collides with the field of the row name, index or children", r.Index, col.Tag)
+		}
+		record[col.Tag] = col.Value
+	}
+	if fields.Name != "" {
+		record[fields.Name] = r.Name
+	}
+	if fields.Index != "" {
+		record[fields.Index] = r.Index
+	}
+	if len(r.Children) > 0 && fields.Child != "" {
+		children := make([]interface{}, 0, len(r.Children))
+		for _, child := range r.Children {
+			rows, err := child.Rows.Records(fields)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, map[string]interface{}{
+				"name": child.Name,
+				"rows": rows,
+			})
+		}
+		record[fields.Child] = children
+	}
+	return record, nil
+}
+
+// Skip checks whether the row is before start-row or in skip-rows
+func (s *ExcelReadSheet) Skip(rowIdx int) bool {
+	if rowIdx < s.StartRow {
+		return true
+	}
+	for _, skip := range s.skipRanges {
+		if skip.Low <= rowIdx && rowIdx <= skip.High {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop checks whether the row matches the stop-when conditions
+func (s *ExcelReadSheet) Stop(src *readRowSource) (bool, error) {
+	if len(s.StopWhen) == 0 {
+		return false, nil
+	}
+	return s.StopWhen.Test(src)
+}
+
+// Pop adds the rows read by the child definition to the current row of the parent
+func (s *readStack) Pop() *readStack {
+	if s.currentRow != nil {
+		s.parent.currentRow.Children = append(s.parent.currentRow.Children, &ExcelDataChild{
+			Name: s.currentRow.Name,
+			Rows: s.allRows,
+		})
+	}
+	return s.parent
+}
+
+// excel_read_close closes the iterators of a sheet and returns the first error
+func excel_read_close(err error, rows *excelize.Rows, stream *readSheetStream) error {
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	if stream != nil {
+		if closeErr := stream.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// excel_read_file_header checks whether the row is the header row of the sheet and collects
+// its texts, it returns nil for other rows
+func excel_read_file_header(cfgHeader *ExcelReadSheetHeader, src *readRowSource) (*readSheetHeader, error) {
+	if cfgHeader.Row > 0 && src.row != cfgHeader.Row {
+		return nil, nil
+	} else if cfgHeader.Row == 0 {
+		if ok, err := cfgHeader.Conditions.Test(src); err != nil || !ok {
+			return nil, err
+		}
+	}
+	header := &readSheetHeader{
+		Row:   src.row,
+		Names: make([]string, 0, len(src.values)),
+		cols:  make(map[*ExcelReadRowCell]string),
+	}
+	for _, cell := range src.values {
+		header.Names = append(header.Names, strings.TrimSpace(cell))
+	}
+	return header, nil
 }
 
 func excel_read_file_configure(ctx context.Context, data *schema.MethodData) (*ExcelReadRepository, error) {
@@ -367,6 +939,7 @@ func excel_read_file_configure(ctx context.Context, data *schema.MethodData) (*E
 				return nil, err
 			} else {
 				repository.Rows[row.Name] = row
+				repository.rowNames = append(repository.rowNames, row.Name)
 			}
 		}
 	}
@@ -403,10 +976,31 @@ func excel_read_file_config_row_cells(ctx context.Context, cellRaw interface{})
 	cellRawArr := cellRaw.([]interface{})
 	for _, item := range cellRawArr {
 		cri := item.(map[string]interface{})
-		cells = append(cells, &ExcelReadRowCell{
-			Col: cri["col"].(string),
-			Tag: cri["tag"].(string),
-		})
+		cell := &ExcelReadRowCell{
+			Col:      cri["col"].(string),
+			Tag:      cri["tag"].(string),
+			Type:     cellTypeAuto,
+			Required: true,
+		}
+		if header, ok := cri["header"].(string); ok {
+			cell.Header = header
+		}
+		if headerPattern, ok := cri["header-pattern"].(string); ok {
+			cell.HeaderPattern = headerPattern
+		}
+		if colsRange, ok := cri["cols"].(string); ok {
+			cell.Cols = colsRange
+		}
+		if source, ok := cri["source"].(string); ok {
+			cell.Source = source
+		}
+		if required, ok := cri["required"].(bool); ok {
+			cell.Required = required
+		}
+		if typ, ok := cri["type"].(string); ok && typ != "" {
+			cell.Type = typ
+		}
+		cells = append(cells, cell)
 	}
 	return cells, nil
 }
@@ -419,33 +1013,13 @@ func excel_read_file_config_row_conds(ctx context.Context, condRaw interface{})
 	condRawArr := condRaw.([]interface{})
 	for _, itemRaw := range condRawArr {
 		rj := itemRaw.(map[string]interface{})
-		conds = append(conds, &ExcelReadRowCondition{
-			Col:     rj["col"].(string),
-			Pattern: rj["pattern"].(string),
-		})
-	}
-	return conds, nil
-}
-
-func excel_read_file_config_sheet_conds(ctx context.Context, condRaw interface{}) (ExcelReadSheetConditions, error) {
-	conds := make(ExcelReadSheetConditions, 0)
-	if condRaw == nil {
-		return conds, nil
-	}
-	condRawArr := condRaw.([]interface{})
-	for _, itemRaw := range condRawArr {
-		rj := itemRaw.(map[string]interface{})
-		if rj["low"].(int) == 0 {
-			conds = append(conds, &ExcelReadSheetCondPattern{
-				Pattern: rj["pattern"].(string),
-			})
-		} else if rj["low"].(int) != 0 {
-			conds = append(conds, &ExcelReadSheetCondRange{
-				Low:  rj["low"].(int),
-				High: rj["high"].(int),
-			})
+		cond := &ExcelReadRowCondition{conditionSpec: conditionSpecOf(rj)}
+		cond.Col, _ = rj["col"].(string)
+		var err error
+		if cond.Any, err = excel_read_file_config_row_conds(ctx, rj["any"]); err != nil {
+			return nil, err
 		}
-
+		conds = append(conds, cond)
 	}
 	return conds, nil
 }
@@ -455,13 +1029,40 @@ func excel_read_file_config_sheet(ctx context.Context, sheetRaw interface{}) (*E
 		return nil, fmt.Errorf("required data")
 	}
 	sr := sheetRaw.(map[string]interface{})
-	conds, err := excel_read_file_config_sheet_conds(ctx, sr["when"])
+	conds := excel_sheet_selectors_config(sr["when"])
+	stopConds, err := excel_read_file_config_row_conds(ctx, sr["stop-when"])
 	if err != nil {
 		return nil, err
 	}
 	sheet := &ExcelReadSheet{
 		Row:        sr["row"].(string),
 		Conditions: conds,
+		StopWhen:   stopConds,
+	}
+	if startRow, ok := sr["start-row"].(int); ok {
+		sheet.StartRow = startRow
+	}
+	if endRow, ok := sr["end-row"].(int); ok {
+		sheet.EndRow = endRow
+	}
+	if skipRows, ok := sr["skip-rows"].(string); ok {
+		sheet.SkipRows = skipRows
+	}
+	if merged, ok := sr["merged"].(string); ok {
+		sheet.Merged = merged
+	}
+	if mergeRange, ok := sr["merge-range"].(bool); ok {
+		sheet.MergeRange = mergeRange
+	}
+	if hr, ok := sr["header"].(map[string]interface{}); ok {
+		headerConds, err := excel_read_file_config_row_conds(ctx, hr["when"])
+		if err != nil {
+			return nil, err
+		}
+		sheet.Header = &ExcelReadSheetHeader{
+			Row:        hr["row"].(int),
+			Conditions: headerConds,
+		}
 	}
 
 	return sheet, nil
@@ -475,9 +1076,11 @@ func _excel_row_element() map[string]*schema.Schema {
 		"cols": {
 			Type: schema.TypeList,
 			Elem: map[string]*schema.Schema{
-				"col":   {Type: schema.TypeString, Required: true},
-				"tag":   {Type: schema.TypeString, Optional: true, DefaultValue: ""},
-				"value": {Type: schema.TypeGeneric, Required: true},
+				"col":    {Type: schema.TypeString, Required: true},
+				"tag":    {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+				"value":  {Type: schema.TypeGeneric, Required: true},
+				"merge":  {Type: schema.TypeString, Optional: true},
+				"source": {Type: schema.TypeString, Optional: true},
 			},
 		},
 		"children": {
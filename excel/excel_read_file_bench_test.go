@@ -0,0 +1,149 @@
+package excel
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+const benchRows = 100000
+
+// benchWorkbook writes a sheet with a header row and rows of text, numbers and dates,
+// with groups every 10 rows a group row G is followed by 9 item rows I
+func benchWorkbook(b *testing.B, rows int, groups bool) string {
+	b.Helper()
+	fileName := filepath.Join(b.TempDir(), fmt.Sprintf("bench%d.xlsx", rows))
+	f := excelize.NewFile()
+	dateStyle, err := f.NewStyle(`{"number_format":14}`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	sw, err := f.NewStreamWriter("Sheet1")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := sw.SetRow("A1", []interface{}{"Type", "Name", "Quantity", "Amount", "Date"}); err != nil {
+		b.Fatal(err)
+	}
+	for row := 2; row <= rows+1; row++ {
+		kind := "AA"
+		if groups {
+			kind = "I"
+			if row%10 == 2 {
+				kind = "G"
+			}
+		}
+		axis, _ := excelize.CoordinatesToCellName(1, row)
+		if err := sw.SetRow(axis, []interface{}{
+			kind,
+			fmt.Sprintf("Name %d", row),
+			row % 100,
+			float64(row) * 1.25,
+			excelize.Cell{StyleID: dateStyle, Value: 44197 + row%365},
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.SaveAs(fileName); err != nil {
+		b.Fatal(err)
+	}
+	return fileName
+}
+
+func benchRepository(b *testing.B, typ string, groups bool) *ExcelReadRepository {
+	b.Helper()
+	cells := func() ExcelReadRowCells {
+		return ExcelReadRowCells{
+			{Col: "B", Tag: "name", Type: typ, Required: true},
+			{Col: "C", Tag: "quantity", Type: typ, Required: true},
+			{Col: "D", Tag: "amount", Type: typ, Required: true},
+			{Col: "E", Tag: "date", Type: typ, Required: true},
+		}
+	}
+	repository := testRepository(ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^AA$"}}}, cells())
+	repository.Sheets[0].Conditions = ExcelSheetSelectors{{Name: "^Sheet1$"}}
+	repository.Sheets[0].Header = &ExcelReadSheetHeader{Row: 1}
+	if groups {
+		repository.Rows["standard"].Conditions[0].Pattern = "^G$"
+		repository.Rows["standard"].Children = []string{"item"}
+		repository.Rows["item"] = &ExcelReadRow{
+			Name:       "item",
+			Conditions: ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^I$"}}},
+			Cells:      cells(),
+		}
+		repository.rowNames = append(repository.rowNames, "item")
+	}
+	if err := repository.Validate(); err != nil {
+		b.Fatal(err)
+	}
+	return repository
+}
+
+// benchReadSheets reads the workbook with the streamed cell metadata and with the cell
+// lookups of excelize, which were used before the stream
+func benchReadSheets(b *testing.B, typ string, groups bool) {
+	fileName := benchWorkbook(b, benchRows, groups)
+	for _, lookupCells := range []bool{false, true} {
+		name := "stream"
+		if lookupCells {
+			name = "lookup"
+		}
+		b.Run(name, func(b *testing.B) {
+			repository := benchRepository(b, typ, groups)
+			repository.lookupCells = lookupCells
+			benchReadRepository(b, fileName, repository, groups)
+		})
+	}
+}
+
+func benchReadRepository(b *testing.B, fileName string, repository *ExcelReadRepository, groups bool) {
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		sheets, err := excel_read_sheets(fileName, repository)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rows := len(sheets[0].Rows)
+		if groups {
+			for _, row := range sheets[0].Rows {
+				for _, child := range row.Children {
+					rows += len(child.Rows)
+				}
+			}
+		}
+		if rows != benchRows {
+			b.Fatalf("expected %d rows, got %d", benchRows, rows)
+		}
+	}
+	b.ReportMetric(float64(benchRows*b.N)/time.Since(start).Seconds(), "rows/s")
+}
+
+func BenchmarkReadSheetsAuto(b *testing.B) {
+	benchReadSheets(b, cellTypeAuto, false)
+}
+
+func BenchmarkReadSheetsString(b *testing.B) {
+	benchReadSheets(b, cellTypeString, false)
+}
+
+func BenchmarkReadSheetsChildren(b *testing.B) {
+	benchReadSheets(b, cellTypeAuto, true)
+}
+
+func BenchmarkReadRowConditions(b *testing.B) {
+	conds := ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^AA$"}}, {Col: "C", conditionSpec: conditionSpec{Pattern: "^[0-9]+$"}}}
+	values := []string{"AA", "Name", "42", "1.25"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if ok, err := conds.Test(&readRowSource{values: values}); err != nil || !ok {
+			b.Fatal("condition not matched")
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package excel
 
 import (
+	"fmt"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	excelize "github.com/xuri/excelize/v2"
+
 	"sbl.systems/go/synwork/plugin-sdk/tunit"
+	"sbl.systems/go/synwork/plugin-sdk/utils"
 )
 
 func TestReadExcelFile01(t *testing.T) {
@@ -59,3 +65,583 @@ func TestReadExcelFile01(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestReadExcelFile02(t *testing.T) {
+	_defs := `
+	method "read_excel_file" "dum" "join01" {
+		file-name = "read01.xlsx"
+		sheet {
+			when {
+				low = 1
+				high = 1
+			}
+			row = "standard"
+		}
+
+		row {
+			name = "standard"
+			when {
+				col = "A"
+				pattern = "AA"
+			}
+			cell {
+				col = "B"
+				tag = "name"
+				type = "string"
+			}
+			cell {
+				col = "D"
+				tag = "cost"
+				type = "number"
+			}
+			cell {
+				col = "E"
+				tag = "vat"
+			}
+		}
+
+	}
+	`
+	mm := tunit.MethodMock{
+		ProcessorDef: Opts.Provider,
+		InstanceMock: nil,
+		ExecFunc:     excel_read_file,
+		References:   map[string]interface{}{},
+	}
+	result := tunit.CallMockMethod(t, mm, _defs)
+	type Read struct {
+		Sheets ExcelDataSheets `json:"sheets"`
+	}
+	resultSheets := &Read{}
+	if err := utils.NewDecoder().Decode(resultSheets, result); err != nil {
+		t.Fatal(err)
+	}
+	if len(resultSheets.Sheets) != 1 || len(resultSheets.Sheets[0].Rows) != 2 {
+		t.Fatal("invalid result rows count")
+	}
+	cols := resultSheets.Sheets[0].Rows[1].Cols
+	if cols[0].Value != "Name2" {
+		t.Fatalf("invalid string value %v", cols[0].Value)
+	}
+	if cols[1].Value != 213.45 {
+		t.Fatalf("invalid number value %v", cols[1].Value)
+	}
+	if cols[2].Value != 20.12 {
+		t.Fatalf("invalid auto value %v", cols[2].Value)
+	}
+}
+
+func TestReadExcelFile03(t *testing.T) {
+	_defs := `
+	method "read_excel_file" "dum" "join01" {
+		file-name = "read01.xlsx"
+		sheet {
+			when {
+				low = 1
+				high = 1
+			}
+			row = "standard"
+			header {
+				when {
+					col = "A"
+					pattern = "^Kennzeichen$"
+				}
+			}
+		}
+
+		row {
+			name = "standard"
+			cell {
+				header = "Name"
+				tag = "name"
+			}
+			cell {
+				header-pattern = "^(VAT|MwSt)$"
+				tag = "vat"
+			}
+			cell {
+				header = "Discount"
+				tag = "discount"
+				required = false
+			}
+		}
+
+	}
+	`
+	mm := tunit.MethodMock{
+		ProcessorDef: Opts.Provider,
+		InstanceMock: nil,
+		ExecFunc:     excel_read_file,
+		References:   map[string]interface{}{},
+	}
+	result := tunit.CallMockMethod(t, mm, _defs)
+	type Read struct {
+		Sheets ExcelDataSheets `json:"sheets"`
+	}
+	resultSheets := &Read{}
+	if err := utils.NewDecoder().Decode(resultSheets, result); err != nil {
+		t.Fatal(err)
+	}
+	if len(resultSheets.Sheets[0].Rows) != 2 {
+		t.Fatal("invalid result rows count")
+	}
+	cols := resultSheets.Sheets[0].Rows[0].Cols
+	if len(cols) != 2 || cols[0].Col != "B" || cols[1].Col != "E" {
+		t.Fatal("invalid header mapping")
+	}
+}
+
+func TestReadExcelFile04(t *testing.T) {
+	_defs := `
+	method "read_excel_file" "dum" "join01" {
+		file-name = "read01.xlsx"
+		output = "records"
+		sheet {
+			when {
+				low = 1
+				high = 1
+			}
+			row = "standard"
+		}
+
+		row {
+			name = "standard"
+			when {
+				col = "A"
+				pattern = "AA"
+			}
+			cell {
+				col = "B"
+				tag = "customer"
+			}
+			cell {
+				col = "D"
+				tag = "cost"
+			}
+		}
+
+	}
+	`
+	mm := tunit.MethodMock{
+		ProcessorDef: Opts.Provider,
+		InstanceMock: nil,
+		ExecFunc:     excel_read_file,
+		References:   map[string]interface{}{},
+	}
+	result := tunit.CallMockMethod(t, mm, _defs)
+	type Read struct {
+		Records []struct {
+			Name  string                   `json:"name"`
+			Index int                      `json:"index"`
+			Rows  []map[string]interface{} `json:"rows"`
+		} `json:"records"`
+	}
+	records := &Read{}
+	if err := utils.NewDecoder().Decode(records, result); err != nil {
+		t.Fatal(err)
+	}
+	if len(records.Records) != 1 || len(records.Records[0].Rows) != 2 {
+		t.Fatal("invalid result records count")
+	}
+	row := records.Records[0].Rows[1]
+	if row["customer"] != "Name2" || row["cost"] != 213.45 || row["_name"] != "standard" {
+		t.Fatalf("invalid record %v", row)
+	}
+}
+
+func TestReadExcelFileRecordFields(t *testing.T) {
+	row := &ExcelDataRow{
+		Name:  "order",
+		Index: 2,
+		Cols:  ExcelDataCols{{Col: "A", Tag: "name", Value: "ACME"}, {Col: "B", Tag: "index", Value: 7}, {Col: "C", Value: "untagged"}},
+		Children: ExcelDataChildren{{
+			Name: "item",
+			Rows: ExcelDataRows{{Name: "item", Index: 3, Cols: ExcelDataCols{{Col: "B", Tag: "article", Value: "pen"}}}},
+		}},
+	}
+	record, err := row.Record(&ExcelReadRecordFields{Name: "_name", Index: "_index", Child: "_child"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record["name"] != "ACME" || record["index"] != 7 || record["_name"] != "order" || record["_index"] != 2 {
+		t.Fatalf("invalid record %v", record)
+	} else if _, ok := record[""]; ok {
+		t.Fatalf("cell without tag in record %v", record)
+	}
+	children, _ := record["_child"].([]interface{})
+	if len(children) != 1 {
+		t.Fatalf("invalid children %v", record["_child"])
+	}
+	if rows := children[0].(map[string]interface{})["rows"].([]interface{}); rows[0].(map[string]interface{})["article"] != "pen" {
+		t.Fatalf("invalid child rows %v", rows)
+	}
+	if record, err = row.Record(&ExcelReadRecordFields{}); err != nil {
+		t.Fatal(err)
+	} else if len(record) != 2 {
+		t.Fatalf("empty field names not omitted %v", record)
+	}
+	if _, err := row.Record(&ExcelReadRecordFields{Name: "name"}); err == nil || !strings.Contains(err.Error(), "tag name collides") {
+		t.Fatalf("colliding tag accepted: %v", err)
+	}
+}
+
+// testWriteRows writes the rows to the sheet starting with row 1
+func testWriteRows(t testing.TB, f *excelize.File, sheetName string, rows [][]interface{}) {
+	t.Helper()
+	for idx, row := range rows {
+		axis, _ := excelize.CoordinatesToCellName(1, idx+1)
+		if err := f.SetSheetRow(sheetName, axis, &row); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testRepository builds a repository reading all sheets with the single row standard
+func testRepository(conditions ExcelReadRowConditions, cells ExcelReadRowCells) *ExcelReadRepository {
+	return &ExcelReadRepository{
+		Sheets: []*ExcelReadSheet{{Row: "standard"}},
+		Rows: map[string]*ExcelReadRow{
+			"standard": {Name: "standard", Conditions: conditions, Cells: cells},
+		},
+		rowNames: []string{"standard"},
+	}
+}
+
+func TestReadExcelFileTrailingCells(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "trailing.xlsx")
+	f := excelize.NewFile()
+	testWriteRows(t, f, "Sheet1", [][]interface{}{
+		{"AA", "Alpha", 3},
+		{"AA", "Beta"},
+		{"AA"},
+	})
+	if err := f.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+	repository := testRepository(ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^AA$"}}}, ExcelReadRowCells{
+		{Col: "B", Tag: "name", Type: cellTypeString},
+		{Col: "C", Tag: "count", Type: cellTypeInt},
+	})
+	if err := repository.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sheets, err := excel_read_sheets(fileName, repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []string{}
+	for _, row := range sheets[0].Rows {
+		cols := []string{}
+		for _, col := range row.Cols {
+			cols = append(cols, fmt.Sprintf("%s:%s=%v", col.Col, col.Tag, col.Value))
+		}
+		rows = append(rows, strings.Join(cols, " "))
+	}
+	want := "B:name=Alpha C:count=3|B:name=Beta C:count=<nil>|B:name=<nil> C:count=<nil>"
+	if got := strings.Join(rows, "|"); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestReadExcelFileValidate(t *testing.T) {
+	repository := &ExcelReadRepository{
+		Sheets: []*ExcelReadSheet{
+			{Row: "missing", Conditions: ExcelSheetSelectors{{Pattern: "("}}, StopWhen: ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "("}}}},
+		},
+		Rows: map[string]*ExcelReadRow{
+			"standard": {
+				Name:       "standard",
+				Children:   []string{"child", "unknown"},
+				Conditions: ExcelReadRowConditions{{Col: "1A", conditionSpec: conditionSpec{Pattern: "^AA$"}}},
+				Cells:      ExcelReadRowCells{{Col: "A", Tag: "sign", Type: cellTypeAuto}, {HeaderPattern: "[", Tag: "name", Type: cellTypeAuto}},
+			},
+			"child": {Name: "child", Children: []string{"standard"}},
+		},
+		rowNames: []string{"standard", "child"},
+	}
+	err := repository.Validate()
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, path := range []string{
+		`sheet[0].when[0].pattern`,
+		`sheet[0].row`,
+		`sheet[0].stop-when[0].pattern:`,
+		`row["standard"].when[0].col`,
+		`row["standard"].cell[1].header-pattern`,
+		`row["standard"].children: row "unknown"`,
+		`row["standard"].children: cycle standard -> child -> standard`,
+	} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("problem %s not reported in %v", path, err)
+		}
+	}
+}
+
+func TestReadExcelFileChildren(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "children.xlsx")
+	f := excelize.NewFile()
+	testWriteRows(t, f, "Sheet1", [][]interface{}{
+		{"G", "group 1"}, {"I", "item 1", 1}, {"I", "item 2", 2},
+		{"G", "group 2"}, {"I", "item 3", 3.5},
+		{"G", "group 3"}, {"I", "item 4", true},
+	})
+	if err := f.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+	repository := &ExcelReadRepository{
+		Sheets: []*ExcelReadSheet{{Row: "group"}},
+		Rows: map[string]*ExcelReadRow{
+			"group": {
+				Name:       "group",
+				Children:   []string{"item"},
+				Conditions: ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^G$"}}},
+				Cells:      ExcelReadRowCells{{Col: "B", Tag: "name", Type: cellTypeAuto}},
+			},
+			"item": {
+				Name:       "item",
+				Conditions: ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^I$"}}},
+				Cells:      ExcelReadRowCells{{Col: "B", Tag: "name", Type: cellTypeString}, {Col: "C", Tag: "value", Type: cellTypeAuto}},
+			},
+		},
+		rowNames: []string{"group", "item"},
+	}
+	if err := repository.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sheets, err := excel_read_sheets(fileName, repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := sheets[0].Rows
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups, got %d", len(groups))
+	}
+	for idx, count := range []int{2, 1, 1} {
+		if len(groups[idx].Children) != 1 || len(groups[idx].Children[0].Rows) != count {
+			t.Fatalf("group %d: children not read", idx+1)
+		}
+	}
+	values := []interface{}{
+		groups[0].Children[0].Rows[1].Cols[1].Value,
+		groups[1].Children[0].Rows[0].Cols[1].Value,
+		groups[2].Children[0].Rows[0].Cols[1].Value,
+	}
+	if values[0] != 2 || values[1] != 3.5 || values[2] != true {
+		t.Fatalf("invalid values %v", values)
+	}
+}
+
+func TestReadExcelFileRowRange(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "range.xlsx")
+	f := excelize.NewFile()
+	testWriteRows(t, f, "Sheet1", [][]interface{}{
+		{"Report"}, {"AA", 1}, {"AA", 2}, {"AA", 3}, {"AA", 4}, {"AA", 5}, {"AA", 6},
+		{"Total", 21}, {"AA", 99},
+	})
+	if err := f.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+	repository := testRepository(
+		ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^AA$"}}},
+		ExcelReadRowCells{{Col: "B", Tag: "value", Type: cellTypeInt}},
+	)
+	repository.Sheets[0].StartRow = 3
+	repository.Sheets[0].SkipRows = "5, 6-6"
+	repository.Sheets[0].StopWhen = ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^Total"}}}
+	if err := repository.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sheets, err := excel_read_sheets(fileName, repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []interface{}{}
+	for _, row := range sheets[0].Rows {
+		values = append(values, row.Cols[0].Value)
+	}
+	if len(values) != 3 || values[0] != 2 || values[1] != 3 || values[2] != 6 {
+		t.Fatalf("invalid rows %v", values)
+	}
+	repository.Sheets[0].EndRow = 4
+	if sheets, err = excel_read_sheets(fileName, repository); err != nil {
+		t.Fatal(err)
+	} else if len(sheets[0].Rows) != 2 {
+		t.Fatalf("end-row not applied, %d rows", len(sheets[0].Rows))
+	}
+	repository.Sheets[0].SkipRows = "4-2"
+	if err := repository.Validate(); err == nil || !strings.Contains(err.Error(), "sheet[0].skip-rows") {
+		t.Fatalf("invalid skip-rows accepted: %v", err)
+	}
+}
+
+func TestReadExcelFileColsRange(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "cols.xlsx")
+	f := excelize.NewFile()
+	testWriteRows(t, f, "Sheet1", [][]interface{}{
+		{"Type", "Name", "Unit", "Jan", "Feb", "Mar"},
+		{"AA", "Alpha", "pcs", 1, nil, 3},
+		{"AA", "Beta", "pcs", 4, 5},
+	})
+	if err := f.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+	newRepository := func(cell *ExcelReadRowCell, header *ExcelReadSheetHeader) *ExcelReadRepository {
+		repository := testRepository(ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^AA$"}}}, ExcelReadRowCells{cell})
+		repository.Sheets[0].Header = header
+		return repository
+	}
+	for _, tc := range []struct {
+		cell   *ExcelReadRowCell
+		header *ExcelReadSheetHeader
+		want   string
+	}{
+		{&ExcelReadRowCell{Cols: "D:*", Tag: "month_{index}", Type: cellTypeInt}, nil, "D:month_1=1 F:month_3=3|D:month_1=4 E:month_2=5"},
+		{&ExcelReadRowCell{Cols: "D:E", Type: cellTypeInt}, &ExcelReadSheetHeader{Row: 1}, "D:Jan=1|D:Jan=4 E:Feb=5"},
+		{&ExcelReadRowCell{Cols: "E:*", Type: cellTypeInt}, nil, "F:F=3|E:E=5"},
+		{&ExcelReadRowCell{Cols: "B:C", Tag: "{col}_{header}", Type: cellTypeString}, &ExcelReadSheetHeader{Row: 1}, "B:B_Name=Alpha C:C_Unit=pcs|B:B_Name=Beta C:C_Unit=pcs"},
+	} {
+		repository := newRepository(tc.cell, tc.header)
+		if err := repository.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		sheets, err := excel_read_sheets(fileName, repository)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := []string{}
+		for _, row := range sheets[0].Rows {
+			cols := []string{}
+			for _, col := range row.Cols {
+				cols = append(cols, fmt.Sprintf("%s:%s=%v", col.Col, col.Tag, col.Value))
+			}
+			rows = append(rows, strings.Join(cols, " "))
+		}
+		if got := strings.Join(rows, "|"); got != tc.want {
+			t.Errorf("cols %s: expected %s, got %s", tc.cell.Cols, tc.want, got)
+		}
+	}
+	for _, cols := range []string{"D", "O:D", "1:*"} {
+		repository := newRepository(&ExcelReadRowCell{Cols: cols, Type: cellTypeAuto}, nil)
+		if err := repository.Validate(); err == nil || !strings.Contains(err.Error(), `row["standard"].cell[0].cols`) {
+			t.Errorf("invalid cols %s accepted: %v", cols, err)
+		}
+	}
+}
+
+func TestReadExcelFileMerged(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "merged.xlsx")
+	f := excelize.NewFile()
+	testWriteRows(t, f, "Sheet1", [][]interface{}{
+		{"Category", "Item", "Group"},
+		{"Books", "Novel", 7}, {nil, "Poems"}, {nil, "Essays"},
+		{"Games", "Chess", 9},
+	})
+	for _, ref := range [][]string{{"A2", "A4"}, {"C2", "C3"}} {
+		if err := f.MergeCell("Sheet1", ref[0], ref[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+	repository := testRepository(ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^Books$"}}}, ExcelReadRowCells{
+		{Col: "A", Tag: "category", Type: cellTypeString},
+		{Col: "B", Tag: "item", Type: cellTypeString},
+		{Col: "C", Tag: "group", Type: cellTypeAuto},
+	})
+	repository.Sheets[0].Merged = readMergedFill
+	repository.Sheets[0].MergeRange = true
+	repository.Sheets[0].Header = &ExcelReadSheetHeader{Row: 1}
+	if err := repository.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sheets, err := excel_read_sheets(fileName, repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []string{}
+	for _, row := range sheets[0].Rows {
+		cols := []string{}
+		for _, col := range row.Cols {
+			cols = append(cols, fmt.Sprintf("%s=%v[%s]", col.Tag, col.Value, col.Merge))
+		}
+		rows = append(rows, strings.Join(cols, " "))
+	}
+	want := "category=Books[A2:A4] item=Novel[] group=7[C2:C3]|" +
+		"category=Books[A2:A4] item=Poems[] group=7[C2:C3]|" +
+		"category=Books[A2:A4] item=Essays[] group=<nil>[]"
+	if got := strings.Join(rows, "|"); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	repository.Sheets[0].Merged = readMergedKeep
+	if sheets, err = excel_read_sheets(fileName, repository); err != nil {
+		t.Fatal(err)
+	} else if len(sheets[0].Rows) != 1 {
+		t.Fatalf("merged cells filled without fill, %d rows", len(sheets[0].Rows))
+	}
+	repository.Sheets[0].Merged = "spread"
+	if err := repository.Validate(); err == nil || !strings.Contains(err.Error(), "sheet[0].merged") {
+		t.Fatalf("invalid merged accepted: %v", err)
+	}
+}
+
+func TestReadExcelFileFormulas(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "formulas.xlsx")
+	f := excelize.NewFile()
+	testWriteRows(t, f, "Sheet1", [][]interface{}{{"AA", 2, 3}, {"AA", 4, 5}})
+	// D1 without cached value like files of other generators, D2 with a stale cached value
+	if err := f.SetCellFormula("Sheet1", "D1", "B1*C1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetCellValue("Sheet1", "D2", 99); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetCellFormula("Sheet1", "D2", "B2*C2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		source string
+		cols   string
+		want   string
+	}{
+		{readSourceCached, "", "C=3/cached D=<nil>/cached|C=5/cached D=99/cached"},
+		{readSourceFormula, "", "C=3/cached D=B1*C1/formula|C=5/cached D=B2*C2/formula"},
+		{readSourceCalculated, "", "C=3/cached D=6/calculated|C=5/cached D=20/calculated"},
+		{readSourceCalculated, "C:*", "C=3/cached D=6/calculated|C=5/cached D=20/calculated"},
+	} {
+		cells := ExcelReadRowCells{
+			{Col: "C", Tag: "c", Type: cellTypeAuto, Source: tc.source},
+			{Col: "D", Tag: "d", Type: cellTypeAuto, Source: tc.source},
+		}
+		if tc.cols != "" {
+			cells = ExcelReadRowCells{{Cols: tc.cols, Type: cellTypeAuto, Source: tc.source}}
+		}
+		repository := testRepository(ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^AA$"}}}, cells)
+		if err := repository.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		sheets, err := excel_read_sheets(fileName, repository)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := []string{}
+		for _, row := range sheets[0].Rows {
+			cols := []string{}
+			for _, col := range row.Cols {
+				cols = append(cols, fmt.Sprintf("%s=%v/%s", col.Col, col.Value, col.Source))
+			}
+			rows = append(rows, strings.Join(cols, " "))
+		}
+		if got := strings.Join(rows, "|"); got != tc.want {
+			t.Errorf("source %s %s: expected %s, got %s", tc.source, tc.cols, tc.want, got)
+		}
+	}
+	repository := testRepository(nil, ExcelReadRowCells{{Col: "D", Type: cellTypeAuto, Source: "fresh"}})
+	if err := repository.Validate(); err == nil || !strings.Contains(err.Error(), `row["standard"].cell[0].source`) {
+		t.Fatalf("invalid source accepted: %v", err)
+	}
+}
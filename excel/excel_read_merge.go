@@ -0,0 +1,111 @@
+package excel
+
+import (
+	"sort"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+const (
+	readMergedKeep = "keep"
+	readMergedFill = "fill"
+)
+
+type (
+	// readMergeRange is a merged range of a sheet with the value of its top-left cell
+	readMergeRange struct {
+		Ref                                string
+		StartCol, StartRow, EndCol, EndRow int
+		anchor                             *readCellValue
+	}
+	// readMerges walks the merged ranges of a sheet along the rows in ascending order
+	readMerges struct {
+		ranges []*readMergeRange
+		next   int
+		active []*readMergeRange
+	}
+)
+
+// excel_read_merges collects the merged ranges of the sheet, the type, raw value and style
+// of the top-left cells are only looked up with meta
+func excel_read_merges(f *excelize.File, sheetName string, date1904, meta bool) (*readMerges, error) {
+	mergeCells, err := f.GetMergeCells(sheetName)
+	if err != nil {
+		return nil, err
+	}
+	merges := &readMerges{ranges: make([]*readMergeRange, 0, len(mergeCells))}
+	for _, mergeCell := range mergeCells {
+		r := &readMergeRange{Ref: mergeCell.GetStartAxis() + ":" + mergeCell.GetEndAxis()}
+		if r.StartCol, r.StartRow, err = excelize.CellNameToCoordinates(mergeCell.GetStartAxis()); err != nil {
+			return nil, err
+		}
+		if r.EndCol, r.EndRow, err = excelize.CellNameToCoordinates(mergeCell.GetEndAxis()); err != nil {
+			return nil, err
+		}
+		value := mergeCell.GetCellValue()
+		r.anchor = &readCellValue{Formatted: value, Raw: value, Date1904: date1904}
+		if meta {
+			axis := mergeCell.GetStartAxis()
+			if r.anchor.Type, err = f.GetCellType(sheetName, axis); err != nil {
+				return nil, err
+			}
+			if r.anchor.Raw, err = f.GetCellValue(sheetName, axis, excelize.Options{RawCellValue: true}); err != nil {
+				return nil, err
+			}
+			if style, err := f.GetCellStyle(sheetName, axis); err != nil {
+				return nil, err
+			} else {
+				r.anchor.IsDate = excel_is_date_style(f, style)
+			}
+		}
+		merges.ranges = append(merges.ranges, r)
+	}
+	sort.SliceStable(merges.ranges, func(i, j int) bool {
+		return merges.ranges[i].StartRow < merges.ranges[j].StartRow
+	})
+	return merges, nil
+}
+
+// Row returns the merged ranges covering the row, rows have to be requested in ascending order
+func (m *readMerges) Row(row int) []*readMergeRange {
+	active := m.active[:0]
+	for _, r := range m.active {
+		if r.EndRow >= row {
+			active = append(active, r)
+		}
+	}
+	for m.next < len(m.ranges) && m.ranges[m.next].StartRow <= row {
+		if r := m.ranges[m.next]; r.EndRow >= row {
+			active = append(active, r)
+		}
+		m.next++
+	}
+	m.active = active
+	return active
+}
+
+// readMergeFill gives the cells covered by the ranges the formatted value of their top-left cell
+func readMergeFill(row int, ranges []*readMergeRange, values []string) []string {
+	for _, r := range ranges {
+		for col := r.StartCol; col <= r.EndCol; col++ {
+			if col == r.StartCol && row == r.StartRow {
+				continue
+			}
+			for len(values) < col {
+				values = append(values, "")
+			}
+			values[col-1] = r.anchor.Formatted
+		}
+	}
+	return values
+}
+
+// readMergeAt returns the range of ranges covering the column
+func readMergeAt(ranges []*readMergeRange, col int) *readMergeRange {
+	for _, r := range ranges {
+		if r.StartCol <= col && col <= r.EndCol {
+			return r
+		}
+	}
+	return nil
+}
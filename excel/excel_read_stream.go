@@ -0,0 +1,281 @@
+package excel
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+type (
+	// readSheetStream streams type, style and raw value of the cells of a worksheet part next
+	// to the rows iterator of excelize, which only returns the values. The cell lookups of
+	// excelize search the whole sheet for every cell, which makes large sheets slow.
+	readSheetStream struct {
+		archive    *zip.ReadCloser
+		part       io.ReadCloser
+		decoder    *xml.Decoder
+		last, next int
+		dateStyles map[int]bool
+	}
+	readStreamCell struct {
+		T string
+		S int
+		V string
+		F bool
+	}
+	readStreamRels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Type   string `xml:"Type,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	readStreamWorkbook struct {
+		Sheets []struct {
+			Name  string     `xml:"name,attr"`
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+)
+
+// excel_read_stream_open opens the worksheet part of the sheet in the xlsx package
+func excel_read_stream_open(fileName, sheetName string) (*readSheetStream, error) {
+	archive, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	partName, err := excel_read_stream_part(&archive.Reader, sheetName)
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+	for _, file := range archive.File {
+		if strings.EqualFold(file.Name, partName) {
+			part, err := file.Open()
+			if err != nil {
+				archive.Close()
+				return nil, err
+			}
+			return &readSheetStream{
+				archive:    archive,
+				part:       part,
+				decoder:    xml.NewDecoder(part),
+				dateStyles: map[int]bool{},
+			}, nil
+		}
+	}
+	archive.Close()
+	return nil, fmt.Errorf("sheet %s: part %s not found", sheetName, partName)
+}
+
+// excel_read_stream_part resolves the worksheet part of a sheet through the workbook relations
+func excel_read_stream_part(archive *zip.Reader, sheetName string) (string, error) {
+	workbookPath := "xl/workbook.xml"
+	rootRels := &readStreamRels{}
+	if err := excel_read_stream_xml(archive, "_rels/.rels", rootRels); err == nil {
+		for _, rel := range rootRels.Relationships {
+			if strings.HasSuffix(rel.Type, "/officeDocument") {
+				workbookPath = strings.TrimPrefix(rel.Target, "/")
+			}
+		}
+	}
+	workbook := &readStreamWorkbook{}
+	if err := excel_read_stream_xml(archive, workbookPath, workbook); err != nil {
+		return "", err
+	}
+	rels := &readStreamRels{}
+	dir, base := path.Split(workbookPath)
+	if err := excel_read_stream_xml(archive, dir+"_rels/"+base+".rels", rels); err != nil {
+		return "", err
+	}
+	for _, sheet := range workbook.Sheets {
+		if sheet.Name != sheetName {
+			continue
+		}
+		for _, attr := range sheet.Attrs {
+			if attr.Name.Local != "id" {
+				continue
+			}
+			for _, rel := range rels.Relationships {
+				if rel.ID == attr.Value {
+					if strings.HasPrefix(rel.Target, "/") {
+						return strings.TrimPrefix(rel.Target, "/"), nil
+					}
+					return path.Join(dir, rel.Target), nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("sheet %s: no worksheet part", sheetName)
+}
+
+func excel_read_stream_xml(archive *zip.Reader, name string, v interface{}) error {
+	for _, file := range archive.File {
+		if strings.EqualFold(file.Name, name) {
+			r, err := file.Open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			return xml.NewDecoder(r).Decode(v)
+		}
+	}
+	return fmt.Errorf("part %s not found", name)
+}
+
+// Row advances to the row and returns its cells by column number, rows have to be
+// requested in ascending order
+func (s *readSheetStream) Row(row int) (map[int]*readStreamCell, error) {
+	for {
+		if s.next == 0 {
+			token, err := s.decoder.Token()
+			if err == io.EOF {
+				return map[int]*readStreamCell{}, nil
+			} else if err != nil {
+				return nil, err
+			}
+			start, ok := token.(xml.StartElement)
+			if !ok || start.Name.Local != "row" {
+				continue
+			}
+			current := s.last + 1
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "r" {
+					if current, err = strconv.Atoi(attr.Value); err != nil {
+						return nil, err
+					}
+				}
+			}
+			s.last, s.next = current, current
+		}
+		current := s.next
+		if current > row {
+			return map[int]*readStreamCell{}, nil
+		}
+		s.next = 0
+		if current < row {
+			if err := s.decoder.Skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return s.cells()
+	}
+}
+
+// cells reads the cells of the current row up to its end element
+func (s *readSheetStream) cells() (map[int]*readStreamCell, error) {
+	cells := map[int]*readStreamCell{}
+	col := 0
+	for {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				if err := s.decoder.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			cell := &readStreamCell{}
+			col++
+			for _, attr := range t.Attr {
+				switch attr.Name.Local {
+				case "r":
+					if col, _, err = excelize.CellNameToCoordinates(attr.Value); err != nil {
+						return nil, err
+					}
+				case "t":
+					cell.T = attr.Value
+				case "s":
+					if cell.S, err = strconv.Atoi(attr.Value); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if cell.V, cell.F, err = s.value(); err != nil {
+				return nil, err
+			}
+			cells[col] = cell
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				return cells, nil
+			}
+		}
+	}
+}
+
+// value reads the text of the v element of the current cell up to its end element and
+// whether the cell has a formula
+func (s *readSheetStream) value() (string, bool, error) {
+	value, inValue, formula := "", false, false
+	for {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return "", false, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			formula = formula || t.Name.Local == "f"
+			if inValue = t.Name.Local == "v"; !inValue {
+				if err := s.decoder.Skip(); err != nil {
+					return "", false, err
+				}
+			}
+		case xml.CharData:
+			if inValue {
+				value += string(t)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "c" {
+				return value, formula, nil
+			}
+			inValue = false
+		}
+	}
+}
+
+// IsDateStyle caches excel_is_date_style for the styles of the sheet
+func (s *readSheetStream) IsDateStyle(f *excelize.File, style int) bool {
+	isDate, ok := s.dateStyles[style]
+	if !ok {
+		isDate = excel_is_date_style(f, style)
+		s.dateStyles[style] = isDate
+	}
+	return isDate
+}
+
+func (s *readSheetStream) Close() error {
+	s.part.Close()
+	return s.archive.Close()
+}
+
+var readStreamCellTypes = map[string]excelize.CellType{
+	"b":         excelize.CellTypeBool,
+	"d":         excelize.CellTypeDate,
+	"n":         excelize.CellTypeNumber,
+	"e":         excelize.CellTypeError,
+	"s":         excelize.CellTypeString,
+	"str":       excelize.CellTypeString,
+	"inlineStr": excelize.CellTypeString,
+}
+
+// Value completes the cell value with the streamed type, raw value and date style,
+// the raw value of strings is the formatted value
+func (c *readStreamCell) Value(v *readCellValue, f *excelize.File, stream *readSheetStream) {
+	v.Type = readStreamCellTypes[c.T]
+	switch c.T {
+	case "", "n", "b", "d":
+		v.Raw = c.V
+	}
+	v.IsDate = stream.IsDateStyle(f, c.S)
+}
@@ -0,0 +1,255 @@
+package excel
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+// readConfigProblems collects the problems of a read configuration with their config path
+type readConfigProblems []string
+
+func (p *readConfigProblems) Add(path string, format string, args ...interface{}) {
+	*p = append(*p, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (p readConfigProblems) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration, %d problem(s):\n\t%s", len(p), strings.Join(p, "\n\t"))
+}
+
+// Validate checks the whole configuration before the file is read: the referenced row
+// definitions exist, the children have no cycles, the columns are valid and all regular
+// expressions compile. Columns and patterns are compiled here once for all rows.
+func (r *ExcelReadRepository) Validate() error {
+	problems := readConfigProblems{}
+	for idx, sheet := range r.Sheets {
+		path := fmt.Sprintf("sheet[%d]", idx)
+		if err := sheet.Conditions.Compile(); err != nil {
+			if condErr, ok := err.(*conditionError); ok {
+				problems.Add(path+"."+condErr.Attr, "%v", condErr.Err)
+			} else {
+				problems.Add(path, "%v", err)
+			}
+		}
+		if _, ok := r.Rows[sheet.Row]; !ok {
+			problems.Add(path+".row", "row %q is not defined", sheet.Row)
+		}
+		if sheet.StartRow < 0 {
+			problems.Add(path+".start-row", "invalid row %d", sheet.StartRow)
+		}
+		if sheet.EndRow < 0 || sheet.EndRow > 0 && sheet.EndRow < sheet.StartRow {
+			problems.Add(path+".end-row", "invalid row %d", sheet.EndRow)
+		}
+		if ranges, err := parseRowRanges(sheet.SkipRows); err != nil {
+			problems.Add(path+".skip-rows", "%v", err)
+		} else {
+			sheet.skipRanges = ranges
+		}
+		validateRowConditions(&problems, path, "stop-when", sheet.StopWhen)
+		switch sheet.Merged {
+		case "", readMergedKeep, readMergedFill:
+		default:
+			problems.Add(path+".merged", "unknown value %s, expected keep or fill", sheet.Merged)
+		}
+		if sheet.Header != nil {
+			if sheet.Header.Row == 0 && len(sheet.Header.Conditions) == 0 {
+				problems.Add(path+".header", "requires row or when")
+			} else if sheet.Header.Row < 0 {
+				problems.Add(path+".header.row", "invalid row %d", sheet.Header.Row)
+			}
+			validateRowConditions(&problems, path+".header", "when", sheet.Header.Conditions)
+		}
+	}
+	seen := map[string]bool{}
+	for _, name := range r.rowNames {
+		path := fmt.Sprintf("row[%q]", name)
+		if seen[name] {
+			problems.Add(path, "row is defined more than once")
+			continue
+		}
+		seen[name] = true
+		row := r.Rows[name]
+		validateRowConditions(&problems, path, "when", row.Conditions)
+		for cellIdx, cell := range row.Cells {
+			cellPath := fmt.Sprintf("%s.cell[%d]", path, cellIdx)
+			if cell.Cols != "" {
+				if cell.Col != "" || cell.ByHeader() {
+					problems.Add(cellPath+".cols", "cols excludes col, header and header-pattern")
+				} else if colsRange, err := parseColRange(cell.Cols); err != nil {
+					problems.Add(cellPath+".cols", "%v", err)
+				} else {
+					cell.colsRange = colsRange
+				}
+			} else if cell.Col == "" && !cell.ByHeader() {
+				problems.Add(cellPath, "requires col, cols, header or header-pattern")
+			} else if cell.Col != "" {
+				cell.colIdx = validateColumn(&problems, cellPath+".col", cell.Col)
+			}
+			if cell.HeaderPattern != "" {
+				cell.headerRegexp = validatePattern(&problems, cellPath+".header-pattern", cell.HeaderPattern)
+			}
+			if _, ok := cellTypeConverters[cell.Type]; !ok {
+				problems.Add(cellPath+".type", "unknown cell type %s", cell.Type)
+			}
+			switch cell.Source {
+			case "", readSourceCached, readSourceFormula, readSourceCalculated:
+			default:
+				problems.Add(cellPath+".source", "unknown source %s, expected cached, formula or calculated", cell.Source)
+			}
+		}
+		for _, child := range row.Children {
+			if _, ok := r.Rows[child]; !ok {
+				problems.Add(path+".children", "row %q is not defined", child)
+			}
+		}
+	}
+	r.validateCycles(&problems)
+	return problems.Err()
+}
+
+// NeedsCellMeta checks whether a cell or a condition needs more than the formatted value of the cell
+func (r *ExcelReadRepository) NeedsCellMeta() bool {
+	for _, sheet := range r.Sheets {
+		if sheet.StopWhen.Typed() || sheet.Header != nil && sheet.Header.Conditions.Typed() {
+			return true
+		}
+	}
+	for _, row := range r.Rows {
+		if row.Conditions.Typed() {
+			return true
+		}
+		for _, cell := range row.Cells {
+			if cell.Type != cellTypeString || cell.Source != "" && cell.Source != readSourceCached {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateCycles reports every row definition which is its own (indirect) child
+func (r *ExcelReadRepository) validateCycles(problems *readConfigProblems) {
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := map[string]int{}
+	var visit func(name string, path []string)
+	visit = func(name string, path []string) {
+		row, ok := r.Rows[name]
+		if !ok || state[name] == done {
+			return
+		}
+		if state[name] == active {
+			for idx, p := range path {
+				if p == name {
+					problems.Add(fmt.Sprintf("row[%q].children", name), "cycle %s", strings.Join(append(path[idx:], name), " -> "))
+					break
+				}
+			}
+			return
+		}
+		state[name] = active
+		for _, child := range row.Children {
+			visit(child, append(path, name))
+		}
+		state[name] = done
+	}
+	for _, name := range r.rowNames {
+		visit(name, nil)
+	}
+}
+
+// parseColRange parses a column range like "D:O" or "D:*" up to the last column
+func parseColRange(spec string) (readColRange, error) {
+	bounds := strings.SplitN(spec, ":", 2)
+	if len(bounds) != 2 {
+		return readColRange{}, fmt.Errorf("invalid column range %q, expected like D:O or D:*", spec)
+	}
+	low, err := excelize.ColumnNameToNumber(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return readColRange{}, fmt.Errorf("invalid column range %q: %w", spec, err)
+	}
+	colRange := readColRange{Low: low}
+	if high := strings.TrimSpace(bounds[1]); high != "*" {
+		if colRange.High, err = excelize.ColumnNameToNumber(high); err != nil {
+			return readColRange{}, fmt.Errorf("invalid column range %q: %w", spec, err)
+		} else if colRange.High < low {
+			return readColRange{}, fmt.Errorf("invalid column range %q", spec)
+		}
+	}
+	return colRange, nil
+}
+
+// parseRowRanges parses a list of rows and row ranges like "2,5-7"
+func parseRowRanges(spec string) ([]readRowRange, error) {
+	ranges := []readRowRange{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		low, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil || low < 1 {
+			return nil, fmt.Errorf("invalid row %q", part)
+		}
+		high := low
+		if len(bounds) == 2 {
+			if high, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || high < low {
+				return nil, fmt.Errorf("invalid row range %q", part)
+			}
+		}
+		ranges = append(ranges, readRowRange{Low: low, High: high})
+	}
+	return ranges, nil
+}
+
+// validateRowConditions checks the condition blocks named block, like when or stop-when
+func validateRowConditions(problems *readConfigProblems, path, block string, conds ExcelReadRowConditions) {
+	for idx, cond := range conds {
+		condPath := fmt.Sprintf("%s.%s[%d]", path, block, idx)
+		validateRowCondition(problems, condPath, cond)
+	}
+}
+
+func validateRowCondition(problems *readConfigProblems, path string, cond *ExcelReadRowCondition) {
+	if cond.Col != "" || len(cond.Any) == 0 {
+		cond.colIdx = validateColumn(problems, path+".col", cond.Col)
+	}
+	if tests, err := cond.conditionSpec.Compile(); err != nil {
+		if condErr, ok := err.(*conditionError); ok {
+			problems.Add(path+"."+condErr.Attr, "%v", condErr.Err)
+		} else {
+			problems.Add(path, "%v", err)
+		}
+	} else {
+		cond.tests = tests
+	}
+	for idx, alternative := range cond.Any {
+		validateRowCondition(problems, fmt.Sprintf("%s.any[%d]", path, idx), alternative)
+	}
+}
+
+func validateColumn(problems *readConfigProblems, path string, col string) int {
+	idx, err := excelize.ColumnNameToNumber(col)
+	if err != nil {
+		problems.Add(path, "invalid column %q", col)
+	}
+	return idx
+}
+
+func validatePattern(problems *readConfigProblems, path string, pattern string) *regexp.Regexp {
+	p, err := regexp.Compile(pattern)
+	if err != nil {
+		problems.Add(path, "%v", err)
+	}
+	return p
+}
@@ -0,0 +1,306 @@
+package excel
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+const (
+	cellTypeAuto   = "auto"
+	cellTypeString = "string"
+	cellTypeNumber = "number"
+	cellTypeInt    = "int"
+	cellTypeBool   = "bool"
+	cellTypeDate   = "date"
+
+	readSourceCached     = "cached"
+	readSourceFormula    = "formula"
+	readSourceCalculated = "calculated"
+)
+
+type (
+	// readRowSource gives access to the current row while applying a row definition
+	readRowSource struct {
+		file     *excelize.File
+		sheet    string
+		row      int
+		values   []string
+		header   *readSheetHeader
+		date1904 bool
+		stream   *readSheetStream
+		meta     map[int]*readStreamCell
+		// merged ranges covering the row, with fill their cells have the top-left value
+		merged     []*readMergeRange
+		fill       bool
+		mergeRange bool
+	}
+	// readCellValue collects everything known about a single cell
+	readCellValue struct {
+		Formatted string
+		Raw       string
+		Type      excelize.CellType
+		IsDate    bool
+		Date1904  bool
+	}
+)
+
+var cellTypeConverters = map[string]func(v *readCellValue) (interface{}, error){
+	cellTypeAuto: func(v *readCellValue) (interface{}, error) {
+		if v.Raw == "" && v.Formatted == "" {
+			return nil, nil
+		}
+		switch v.Type {
+		case excelize.CellTypeBool:
+			return v.Raw == "1" || strings.EqualFold(v.Raw, "true"), nil
+		case excelize.CellTypeString, excelize.CellTypeError:
+			return v.Formatted, nil
+		case excelize.CellTypeDate:
+			return excel_cell_to_date(v)
+		}
+		number, err := strconv.ParseFloat(v.Raw, 64)
+		if err != nil {
+			return v.Formatted, nil
+		}
+		if v.IsDate {
+			return excel_cell_to_date(v)
+		}
+		if number == math.Trunc(number) && math.Abs(number) < 1<<53 {
+			return int(number), nil
+		}
+		return number, nil
+	},
+	cellTypeString: func(v *readCellValue) (interface{}, error) {
+		if v.Raw == "" && v.Formatted == "" {
+			return nil, nil
+		}
+		return v.Formatted, nil
+	},
+	cellTypeNumber: func(v *readCellValue) (interface{}, error) {
+		if strings.TrimSpace(v.Raw) == "" {
+			return nil, nil
+		}
+		return strconv.ParseFloat(strings.TrimSpace(v.Raw), 64)
+	},
+	cellTypeInt: func(v *readCellValue) (interface{}, error) {
+		if strings.TrimSpace(v.Raw) == "" {
+			return nil, nil
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(v.Raw), 64)
+		if err != nil {
+			return nil, err
+		} else if number != math.Trunc(number) {
+			return nil, fmt.Errorf("%v is not an integer", number)
+		}
+		return int(number), nil
+	},
+	cellTypeBool: func(v *readCellValue) (interface{}, error) {
+		if strings.TrimSpace(v.Raw) == "" {
+			return nil, nil
+		}
+		switch strings.ToLower(strings.TrimSpace(v.Raw)) {
+		case "1", "true", "yes", "y", "x":
+			return true, nil
+		case "0", "false", "no", "n":
+			return false, nil
+		}
+		return nil, fmt.Errorf("no boolean value")
+	},
+	cellTypeDate: excel_cell_to_date,
+}
+
+var readDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"02.01.2006",
+	"01/02/2006",
+	"01-02-06",
+}
+
+// excel_cell_to_date converts a date serial number or a date string to an ISO-8601 timestamp
+func excel_cell_to_date(v *readCellValue) (interface{}, error) {
+	raw := strings.TrimSpace(v.Raw)
+	if raw == "" {
+		return nil, nil
+	}
+	if number, err := strconv.ParseFloat(raw, 64); err == nil {
+		t, err := excelize.ExcelDateToTime(number, v.Date1904)
+		if err != nil {
+			return nil, err
+		}
+		return t.Format(time.RFC3339), nil
+	}
+	for _, layout := range readDateLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.Format(time.RFC3339), nil
+		}
+	}
+	return nil, fmt.Errorf("no date value")
+}
+
+// Content reads the cell of the current row from the source of the cell definition and
+// returns the source used. Cells without formula return the cached value.
+func (c *ExcelReadRowCell) Content(src *readRowSource, col string, idx int) (interface{}, string, error) {
+	if c.Source == "" || c.Source == readSourceCached {
+		value, err := c.Value(src, col, idx)
+		return value, readSourceCached, err
+	}
+	formula, err := src.Formula(col, idx)
+	if err != nil {
+		return nil, "", err
+	} else if formula == "" {
+		value, err := c.Value(src, col, idx)
+		return value, readSourceCached, err
+	} else if c.Source == readSourceFormula {
+		return formula, readSourceFormula, nil
+	}
+	result, err := src.file.CalcCellValue(src.sheet, fmt.Sprintf("%s%d", col, src.row))
+	if err != nil {
+		return nil, "", fmt.Errorf("sheet %s row %d col %s: cannot calculate %s: %w", src.sheet, src.row, col, formula, err)
+	}
+	v, err := src.Cell(col, idx)
+	if err != nil {
+		return nil, "", err
+	}
+	v.Raw, v.Formatted, v.Type = result, result, excelize.CellTypeUnset
+	value, err := c.convert(src, col, v)
+	return value, readSourceCalculated, err
+}
+
+// Value reads the cell of the current row and converts it to the configured type
+func (c *ExcelReadRowCell) Value(src *readRowSource, col string, idx int) (interface{}, error) {
+	v := &readCellValue{Raw: src.values[idx-1], Formatted: src.values[idx-1]}
+	if c.Type != cellTypeString {
+		// only strings are complete with the formatted value
+		var err error
+		if v, err = src.Cell(col, idx); err != nil {
+			return nil, err
+		}
+	}
+	return c.convert(src, col, v)
+}
+
+func (c *ExcelReadRowCell) convert(src *readRowSource, col string, v *readCellValue) (interface{}, error) {
+	typ := c.Type
+	if typ == "" {
+		typ = cellTypeAuto
+	}
+	converter, ok := cellTypeConverters[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown cell type %s for column %s", typ, col)
+	}
+	if value, err := converter(v); err != nil {
+		return nil, fmt.Errorf("sheet %s row %d col %s: cannot convert %q to %s: %w", src.sheet, src.row, col, v.Formatted, typ, err)
+	} else {
+		return value, nil
+	}
+}
+
+// Cell collects type, raw and formatted value of a cell of the current row
+func (s *readRowSource) Cell(col string, idx int) (*readCellValue, error) {
+	if s.fill {
+		if r := readMergeAt(s.merged, idx); r != nil && !(r.StartCol == idx && r.StartRow == s.row) {
+			anchor := *r.anchor
+			return &anchor, nil
+		}
+	}
+	v := &readCellValue{Raw: s.values[idx-1], Formatted: s.values[idx-1], Date1904: s.date1904}
+	if s.file == nil {
+		return v, nil
+	} else if s.stream != nil {
+		if meta, ok := s.meta[idx]; ok {
+			meta.Value(v, s.file, s.stream)
+		}
+		return v, nil
+	}
+	axis := fmt.Sprintf("%s%d", col, s.row)
+	var err error
+	if v.Type, err = s.file.GetCellType(s.sheet, axis); err != nil {
+		return nil, err
+	}
+	if v.Raw, err = s.file.GetCellValue(s.sheet, axis, excelize.Options{RawCellValue: true}); err != nil {
+		return nil, err
+	}
+	if style, err := s.file.GetCellStyle(s.sheet, axis); err != nil {
+		return nil, err
+	} else {
+		v.IsDate = excel_is_date_style(s.file, style)
+	}
+	return v, nil
+}
+
+// Formula returns the formula of a cell, "" for cells without formula
+func (s *readRowSource) Formula(col string, idx int) (string, error) {
+	if s.file == nil {
+		return "", nil
+	} else if s.stream != nil {
+		if meta, ok := s.meta[idx]; !ok || !meta.F {
+			return "", nil
+		}
+	}
+	return s.file.GetCellFormula(s.sheet, fmt.Sprintf("%s%d", col, s.row))
+}
+
+// MergeRef returns the merged range covering the column with merge-range, otherwise ""
+func (s *readRowSource) MergeRef(idx int) string {
+	if !s.mergeRange {
+		return ""
+	} else if r := readMergeAt(s.merged, idx); r != nil {
+		return r.Ref
+	}
+	return ""
+}
+
+// excel_is_date_style checks whether the number format of a style displays a date or time
+func excel_is_date_style(f *excelize.File, style int) bool {
+	if f.Styles == nil || f.Styles.CellXfs == nil || style <= 0 || style >= len(f.Styles.CellXfs.Xf) {
+		return false
+	}
+	numFmtID := f.Styles.CellXfs.Xf[style].NumFmtID
+	if numFmtID == nil {
+		return false
+	}
+	switch id := *numFmtID; {
+	case 14 <= id && id <= 22, 27 <= id && id <= 36, 45 <= id && id <= 47, 50 <= id && id <= 58:
+		return true
+	}
+	if f.Styles.NumFmts == nil {
+		return false
+	}
+	for _, numFmt := range f.Styles.NumFmts.NumFmt {
+		if numFmt.NumFmtID == *numFmtID {
+			return excel_is_date_format(numFmt.FormatCode)
+		}
+	}
+	return false
+}
+
+// excel_is_date_format checks a custom number format code for date or time tokens,
+// ignoring quoted text, escaped characters and bracket sections like [Red]
+func excel_is_date_format(code string) bool {
+	inQuote, inBracket := false, false
+	for i := 0; i < len(code); i++ {
+		ch := code[i]
+		switch {
+		case inQuote:
+			inQuote = ch != '"'
+		case inBracket:
+			inBracket = ch != ']'
+		case ch == '"':
+			inQuote = true
+		case ch == '[':
+			inBracket = true
+		case ch == '\\' || ch == '_' || ch == '*':
+			i++
+		case strings.ContainsRune("yYmMdDhHsS", rune(ch)):
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package excel
+
+import (
+	"fmt"
+	"regexp"
+
+	"sbl.systems/go/synwork/plugin-sdk/schema"
+)
+
+type (
+	// ExcelSheetSelector selects sheets in the when blocks of read_excel_file and modify_rows,
+	// all attributes set have to match. Indexes start with 1 like the index of the result.
+	ExcelSheetSelector struct {
+		Name       string
+		Pattern    string
+		Exact      string
+		Low        int
+		High       int
+		Not        bool
+		SkipHidden bool
+		Any        ExcelSheetSelectors
+		pName      *regexp.Regexp
+	}
+	ExcelSheetSelectors []*ExcelSheetSelector
+)
+
+// _excel_sheet_selector returns the schema of a sheet when block with its any blocks
+func _excel_sheet_selector() map[string]*schema.Schema {
+	selector := func() map[string]*schema.Schema {
+		return map[string]*schema.Schema{
+			"name":        {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+			"pattern":     {Type: schema.TypeString, Optional: true, DefaultValue: ""}, // deprecated, use name
+			"exact":       {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+			"low":         {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
+			"high":        {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
+			"not":         {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+			"skip-hidden": {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		}
+	}
+	elem := selector()
+	elem["any"] = &schema.Schema{Type: schema.TypeList, Optional: true, Elem: selector()}
+	return elem
+}
+
+// excel_sheet_selectors_config reads the sheet when blocks of the raw configuration
+func excel_sheet_selectors_config(raw interface{}) ExcelSheetSelectors {
+	selectors := make(ExcelSheetSelectors, 0)
+	items, _ := raw.([]interface{})
+	for _, item := range items {
+		rj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		selector := &ExcelSheetSelector{}
+		selector.Name, _ = rj["name"].(string)
+		selector.Pattern, _ = rj["pattern"].(string)
+		selector.Exact, _ = rj["exact"].(string)
+		selector.Low, _ = rj["low"].(int)
+		selector.High, _ = rj["high"].(int)
+		selector.Not, _ = rj["not"].(bool)
+		selector.SkipHidden, _ = rj["skip-hidden"].(bool)
+		selector.Any = excel_sheet_selectors_config(rj["any"])
+		selectors = append(selectors, selector)
+	}
+	return selectors
+}
+
+// Compile checks the selectors and compiles their regular expressions
+func (s ExcelSheetSelectors) Compile() error {
+	for idx, selector := range s {
+		if err := selector.Compile(); err != nil {
+			if condErr, ok := err.(*conditionError); ok {
+				return &conditionError{fmt.Sprintf("when[%d].%s", idx, condErr.Attr), condErr.Err}
+			}
+			return fmt.Errorf("when[%d]: %w", idx, err)
+		}
+	}
+	return nil
+}
+
+// Compile checks the selector, the deprecated pattern is the same as name
+func (s *ExcelSheetSelector) Compile() error {
+	attr, name := "name", s.Name
+	if s.Pattern != "" {
+		if s.Name != "" {
+			return &conditionError{"pattern", fmt.Errorf("name and pattern exclude each other")}
+		}
+		attr, name = "pattern", s.Pattern
+	}
+	if name != "" {
+		var err error
+		if s.pName, err = regexp.Compile(name); err != nil {
+			return &conditionError{attr, err}
+		}
+	}
+	if s.Low < 0 {
+		return &conditionError{"low", fmt.Errorf("invalid index %d", s.Low)}
+	} else if s.High < 0 || s.High > 0 && s.High < s.Low {
+		return &conditionError{"high", fmt.Errorf("invalid index %d", s.High)}
+	}
+	for idx, alternative := range s.Any {
+		if err := alternative.Compile(); err != nil {
+			if condErr, ok := err.(*conditionError); ok {
+				return &conditionError{fmt.Sprintf("any[%d].%s", idx, condErr.Attr), condErr.Err}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Test combines the selectors with AND, index is 1-based
+func (s ExcelSheetSelectors) Test(name string, index int, hidden bool) (bool, error) {
+	for _, selector := range s {
+		if ok, err := selector.Test(name, index, hidden); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Test checks name, index and visibility and whether one of the any blocks matches, not
+// inverts the result of the whole block
+func (s *ExcelSheetSelector) Test(name string, index int, hidden bool) (bool, error) {
+	if s.pName == nil && (s.Name != "" || s.Pattern != "") {
+		if err := s.Compile(); err != nil {
+			return false, err
+		}
+	}
+	ok := (s.pName == nil || s.pName.MatchString(name)) &&
+		(s.Exact == "" || s.Exact == name) &&
+		(s.Low == 0 || s.Low <= index) &&
+		(s.High == 0 || index <= s.High) &&
+		!(s.SkipHidden && hidden)
+	if ok && len(s.Any) > 0 {
+		ok = false
+		for _, alternative := range s.Any {
+			if match, err := alternative.Test(name, index, hidden); err != nil {
+				return false, err
+			} else if match {
+				ok = true
+				break
+			}
+		}
+	}
+	return ok != s.Not, nil
+}
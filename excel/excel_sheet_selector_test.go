@@ -0,0 +1,108 @@
+package excel
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+func TestSheetSelector(t *testing.T) {
+	sheets := []struct {
+		name   string
+		index  int
+		hidden bool
+	}{
+		{"Orders 2021", 1, false}, {"Orders 2022", 2, true}, {"Notes", 3, false}, {"Summary", 4, false},
+	}
+	for _, tc := range []struct {
+		label     string
+		selectors ExcelSheetSelectors
+		want      string
+	}{
+		{"name", ExcelSheetSelectors{{Name: "^Orders"}}, "Orders 2021,Orders 2022"},
+		{"pattern", ExcelSheetSelectors{{Pattern: "2022$"}}, "Orders 2022"},
+		{"exact", ExcelSheetSelectors{{Exact: "Notes"}}, "Notes"},
+		{"index", ExcelSheetSelectors{{Low: 1, High: 1}}, "Orders 2021"},
+		{"index open", ExcelSheetSelectors{{Low: 3}}, "Notes,Summary"},
+		{"not", ExcelSheetSelectors{{Name: "^Orders", Not: true}}, "Notes,Summary"},
+		{"skip hidden", ExcelSheetSelectors{{Name: "^Orders", SkipHidden: true}}, "Orders 2021"},
+		{"any", ExcelSheetSelectors{{Any: ExcelSheetSelectors{{Exact: "Notes"}, {Low: 4, High: 4}}}}, "Notes,Summary"},
+		{"and", ExcelSheetSelectors{{Low: 2}, {Name: "s$"}}, "Notes"},
+		{"none", ExcelSheetSelectors{}, "Orders 2021,Orders 2022,Notes,Summary"},
+	} {
+		if err := tc.selectors.Compile(); err != nil {
+			t.Fatalf("%s: %v", tc.label, err)
+		}
+		selected := []string{}
+		for _, sheet := range sheets {
+			if ok, err := tc.selectors.Test(sheet.name, sheet.index, sheet.hidden); err != nil {
+				t.Fatalf("%s: %v", tc.label, err)
+			} else if ok {
+				selected = append(selected, sheet.name)
+			}
+		}
+		if got := strings.Join(selected, ","); got != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.label, tc.want, got)
+		}
+	}
+}
+
+func TestSheetSelectorCompile(t *testing.T) {
+	for _, tc := range []struct {
+		selectors ExcelSheetSelectors
+		problem   string
+	}{
+		{ExcelSheetSelectors{{Name: "("}}, "when[0].name"},
+		{ExcelSheetSelectors{{}, {Name: "a", Pattern: "b"}}, "when[1].pattern"},
+		{ExcelSheetSelectors{{Low: -1}}, "when[0].low"},
+		{ExcelSheetSelectors{{Low: 3, High: 2}}, "when[0].high"},
+		{ExcelSheetSelectors{{Any: ExcelSheetSelectors{{Name: "["}}}}, "when[0].any[0].name"},
+	} {
+		if err := tc.selectors.Compile(); err == nil || !strings.HasPrefix(err.Error(), tc.problem+":") {
+			t.Errorf("expected problem %s, got %v", tc.problem, err)
+		}
+	}
+}
+
+func TestSheetSelectorRead(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "sheets.xlsx")
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", "First")
+	for _, name := range []string{"Second", "Third"} {
+		f.NewSheet(name)
+	}
+	for _, name := range []string{"First", "Second", "Third"} {
+		if err := f.SetCellValue(name, "A1", "AA"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.SetSheetVisible("Second", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+	repository := testRepository(
+		ExcelReadRowConditions{{Col: "A", conditionSpec: conditionSpec{Pattern: "^AA$"}}},
+		ExcelReadRowCells{{Col: "A", Tag: "sign", Type: cellTypeString}},
+	)
+	repository.Sheets[0].Conditions = ExcelSheetSelectors{{Low: 2, High: 3}}
+	if err := repository.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	sheets, err := excel_read_sheets(fileName, repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sheets) != 2 || sheets[0].Name != "Second" || sheets[0].Index != 2 || !sheets[0].Hidden || sheets[1].Hidden {
+		t.Fatalf("invalid sheets, %d sheets", len(sheets))
+	}
+	repository.Sheets[0].Conditions[0].SkipHidden = true
+	if sheets, err = excel_read_sheets(fileName, repository); err != nil {
+		t.Fatal(err)
+	} else if len(sheets) != 1 || sheets[0].Name != "Third" || sheets[0].Index != 3 {
+		t.Fatalf("hidden sheet not skipped, %d sheets", len(sheets))
+	}
+}
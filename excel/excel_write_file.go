@@ -1,12 +1,16 @@
 package excel
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"path/filepath"
+	"regexp"
 	"strings"
 
 	excelize "github.com/xuri/excelize/v2"
 	"sbl.systems/go/synwork/plugin-sdk/schema"
+	"sbl.systems/go/synwork/plugin-sdk/utils"
 )
 
 // https://xuri.me/excelize/en/cell.html#SetCellStyle
@@ -16,11 +20,21 @@ var (
 		"value":        {Type: schema.TypeString, Optional: true},
 		"double_value": {Type: schema.TypeFloat, Optional: true},
 		"int_value":    {Type: schema.TypeInt, Optional: true},
+		"formula":      {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+		"formula-type": {Type: schema.TypeString, Optional: true, DefaultValue: excelize.STCellFormulaTypeNormal},
+		"formula-ref":  {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+		"cached-value": {Type: schema.TypeGeneric, Optional: true},
 		"style":        {Type: schema.TypeString, Optional: true, DefaultValue: ""},
 	}
 	excel_sheet = map[string]*schema.Schema{
-		"name": {Type: schema.TypeString, Required: true, DefaultValue: "Sheet1"},
-		"cell": {Type: schema.TypeList, Required: true, Elem: excel_cell},
+		"name":        {Type: schema.TypeString, Required: true, DefaultValue: "Sheet1"},
+		"cell":        {Type: schema.TypeList, Required: true, Elem: excel_cell},
+		"column":      {Type: schema.TypeList, Optional: true, Elem: excel_column},
+		"row":         {Type: schema.TypeList, Optional: true, Elem: excel_row},
+		"auto-fit":    {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		"freeze":      {Type: schema.TypeMap, Optional: true, Elem: excel_freeze},
+		"auto-filter": {Type: schema.TypeMap, Optional: true, Elem: excel_auto_filter},
+		"table":       {Type: schema.TypeList, Optional: true, Elem: excel_table},
 	}
 	excel_style = map[string]*schema.Schema{
 		"name":           {Type: schema.TypeString, Required: true},
@@ -76,10 +90,32 @@ var (
 			},
 		},
 	}
+	excel_tag_style = map[string]*schema.Schema{
+		"tag":      {Type: schema.TypeString, Optional: true, DefaultValue: ".*"},
+		"row-type": {Type: schema.TypeString, Optional: true, DefaultValue: ".*"},
+		"style":    {Type: schema.TypeString, Required: true},
+	}
 	excel_file = map[string]*schema.Schema{
-		"file-name": {Type: schema.TypeString, Required: true, DefaultValue: "file1.xlsx"},
-		"sheet":     {Type: schema.TypeList, Optional: true, Elem: excel_sheet},
-		"style":     {Type: schema.TypeList, Optional: true, Elem: excel_style},
+		"file-name":     {Type: schema.TypeString, Required: true, DefaultValue: "file1.xlsx"},
+		"template-file": {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+		"in-place":      {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		"values":        {Type: schema.TypeGeneric, Optional: true},
+		"sheet":         {Type: schema.TypeList, Optional: true, Elem: excel_sheet},
+		"style":         {Type: schema.TypeList, Optional: true, Elem: excel_style},
+		"sheets": {
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem: map[string]*schema.Schema{
+				"name":   {Type: schema.TypeString, Required: true},
+				"index":  {Type: schema.TypeInt, Required: true},
+				"hidden": {Type: schema.TypeBool, Optional: true},
+				"rows": {
+					Type: schema.TypeList,
+					Elem: _excel_row_element(),
+				},
+			},
+		},
+		"tag-style": {Type: schema.TypeList, Optional: true, Elem: excel_tag_style},
 	}
 )
 
@@ -102,6 +138,23 @@ var Method_write_file = &schema.Method{
 				double_value = 1.22
 				style = "grey"
 			}
+			cell {
+				name = "D2"
+				formula = "SUM(A2:C3)" // a leading = is removed
+				cached-value = 1.22 // value shown by viewers which don't recalculate
+			}
+			cell {
+				name = "E2"
+				formula = "D2*2"
+				formula-type = "shared" // the formula of E3:E10 is adjusted to their rows
+				formula-ref = "E2:E10"
+			}
+			cell {
+				name = "F2"
+				formula = "SUM(A2:A3*B2:B3)"
+				formula-type = "array"
+				formula-ref = "F2:F2" // default the cell
+			}
 		}
 		style {
 			name = "grey"
@@ -112,20 +165,189 @@ var Method_write_file = &schema.Method{
 			}
 		}
 	}
+
+	With template-file an existing .xlsx or .xltx is opened instead of creating a new
+	workbook. Existing sheets, content and styles are kept, only the configured cells
+	are written. The result is saved as file-name, or with in-place = true back to
+	the template-file.
+
+	method "write_excel_file" "processor-instance" "method-instance" {
+		template-file = "invoice.xltx"
+		file-name = "invoice-4711.xlsx"
+		sheet {
+			name = "Invoice"
+			cell {
+				name = "B4"
+				value = "4711"
+			}
+		}
+	}
+
+	values fills the placeholders of the template. Every {{path}} in any cell is replaced,
+	a path is resolved in the values map like customer.name or items.0.name. A cell with
+	only a placeholder keeps the type of the value. A row with {{#each items}} in one of
+	its cells is repeated for every entry of the list, the rows below are shifted down.
+	Inside this row {{name}} addresses the entry first, {{this}} the entry itself.
+
+	method "write_excel_file" "processor-instance" "method-instance" {
+		template-file = "invoice.xltx"
+		file-name = "invoice-4711.xlsx"
+		values = {
+			customer = { name = "ACME" }
+			items = $method.read.items
+		}
+	}
+
+	column and row blocks set the layout of a sheet. range is a single column or a range
+	like B:D, a column style is used by all cells of the column without a style of their own.
+	auto-fit estimates the width of all columns without a width from the longest content
+	and its font size.
+
+	method "write_excel_file" "processor-instance" "method-instance" {
+		file-name = "test03.xlsx"
+		sheet {
+			name = "sheet01"
+			auto-fit = true
+			column {
+				range = "A"
+				width = 30
+			}
+			column {
+				range = "D:E"
+				hidden = true
+				outline-level = 1 // grouped, max 7
+				style = "grey"
+			}
+			row {
+				index = 1
+				height = 24
+				style = "grey"
+			}
+			cell { 
+				name = "A1"
+				value = "content of field A1"
+			}
+		}
+	}
+
+	freeze keeps the first rows and cols visible while scrolling, auto-filter adds filter
+	buttons to the header of a range. A table block turns a range with a header row into an
+	excel table with a name and a table style. Table names have to be unique in the workbook,
+	the ranges of the tables of a sheet must not overlap each other, the tables of the template
+	or the auto-filter, and the header cells of a table must be unique and not empty.
+
+	method "write_excel_file" "processor-instance" "method-instance" {
+		file-name = "test04.xlsx"
+		sheet {
+			name = "orders"
+			freeze {
+				rows = 1
+				cols = 0
+			}
+			table {
+				name = "Orders"
+				range = "A1:D20"
+				style = "TableStyleMedium2" // default, TableStyleLight1 - 21, Medium1 - 28, Dark1 - 11
+				banded-rows = true // default
+				banded-columns = false
+			}
+			cell { 
+				name = "A1"
+				value = "Order"
+			}
+		}
+		sheet {
+			name = "notes"
+			auto-filter {
+				range = "A1:C10"
+			}
+			cell { 
+				name = "A1"
+				value = "Note"
+			}
+		}
+	}
+
+	Formulas are written without calculation, excel calculates them when the file is opened.
+
+	The result of read_excel_file or modify_rows can be written back with sheets. Every row
+	is written to its index, every col to its column, children rows included. tag-style
+	applies a style to all cols with a matching tag (and row-type), both are regular expressions.
+
+	method "write_excel_file" "processor-instance" "method-instance" {
+		file-name = "test02.xlsx"
+		sheets = $method.modify.sheets
+		tag-style {
+			tag = "^total$"
+			row-type = "standard"
+			style = "grey"
+		}
+	}
 	
 	`,
 }
 
+const (
+	contentTypeWorkbook = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"
+	contentTypeTemplate = "application/vnd.openxmlformats-officedocument.spreadsheetml.template.main+xml"
+)
+
+type (
+	ExcelWriteTagStyle struct {
+		Tag       string
+		RowType   string
+		Style     int
+		tagRegexp *regexp.Regexp
+		rowRegexp *regexp.Regexp
+	}
+	ExcelWriteTagStyles []*ExcelWriteTagStyle
+)
+
+// Find returns the style of the first mapping matching row type and tag
+func (t ExcelWriteTagStyles) Find(rowType, tag string) (int, bool) {
+	for _, ts := range t {
+		if ts.rowRegexp.MatchString(rowType) && ts.tagRegexp.MatchString(tag) {
+			return ts.Style, true
+		}
+	}
+	return 0, false
+}
+
 func excel_write_file(ctx context.Context, data *schema.MethodData, client interface{}) error {
-	f := excelize.NewFile()
 	fileName := data.GetConfig("file-name").(string)
-	sheets := data.GetConfig("sheet").([]interface{})
+	templateFile, _ := data.GetConfig("template-file").(string)
+	var f *excelize.File
+	if templateFile != "" {
+		var err error
+		if f, err = excelize.OpenFile(templateFile); err != nil {
+			return err
+		}
+		if inPlace, _ := data.GetConfig("in-place").(bool); inPlace {
+			fileName = templateFile
+		}
+	} else {
+		f = excelize.NewFile()
+	}
+	sheets, _ := data.GetConfig("sheet").([]interface{})
+	if err := excel_write_tables_validate(f, sheets); err != nil {
+		return err
+	}
 	styles, err := excel_define_styles(ctx, f, data)
 	if err != nil {
 		return err
 	}
+	tagStyles, err := excel_define_tag_styles(ctx, styles, data)
+	if err != nil {
+		return err
+	}
+	if values := data.GetConfig("values"); values != nil {
+		if err := excel_write_placeholders(ctx, f, values); err != nil {
+			return err
+		}
+	}
+	hasFormula := false
 	sheetsToRemove := map[string]bool{}
-	for count := f.SheetCount; count > 0; count-- {
+	for count := f.SheetCount; count > 0 && templateFile == ""; count-- {
 		sheetName := f.GetSheetName(count - 1)
 		sheetsToRemove[sheetName] = true
 	}
@@ -136,6 +358,9 @@ func excel_write_file(ctx context.Context, data *schema.MethodData, client inter
 			delete(sheetsToRemove, sheetName)
 		}
 		f.NewSheet(sheetName)
+		if err := excel_write_columns(f, sheetName, sheet, styles); err != nil {
+			return err
+		}
 		for _, c := range sheet["cell"].([]interface{}) {
 			cell := c.(map[string]interface{})
 			cellName := cell["name"].(string)
@@ -155,6 +380,13 @@ func excel_write_file(ctx context.Context, data *schema.MethodData, client inter
 			if style, existStyle := styles[cell["style"].(string)]; existStyle {
 				f.SetCellStyle(sheetName, cellName, cellEnd, style)
 			}
+			if formula, _ := cell["formula"].(string); formula != "" {
+				if err := excel_write_formula(f, sheetName, cellName, cell); err != nil {
+					return err
+				}
+				hasFormula = true
+				continue
+			}
 			for _, k := range []string{"value", "int_value", "double_value"} {
 				if v := cell[k]; v != nil {
 
@@ -168,16 +400,122 @@ func excel_write_file(ctx context.Context, data *schema.MethodData, client inter
 		next_cell:
 		}
 	}
+	if err := excel_write_data_sheets(ctx, f, data, tagStyles, sheetsToRemove); err != nil {
+		return err
+	}
+	for _, s := range sheets {
+		sheet := s.(map[string]interface{})
+		if err := excel_write_layout(f, sheet["name"].(string), sheet, styles); err != nil {
+			return err
+		}
+		if err := excel_write_tables(f, sheet["name"].(string), sheet); err != nil {
+			return err
+		}
+	}
 	for k, _ := range sheetsToRemove {
 		f.DeleteSheet(k)
 	}
-
+	if hasFormula {
+		excel_write_full_calc(f)
+	}
+	if !strings.EqualFold(filepath.Ext(fileName), ".xltx") {
+		excel_template_to_workbook(f)
+	}
 	if err := f.SaveAs(fileName); err != nil {
 		return err
 	}
 	return nil
 }
 
+// excel_template_to_workbook switches the content type of a workbook opened from
+// a .xltx template, otherwise excel refuses to open the saved .xlsx file
+func excel_template_to_workbook(f *excelize.File) {
+	if f.ContentTypes != nil {
+		for idx, override := range f.ContentTypes.Overrides {
+			if override.ContentType == contentTypeTemplate {
+				f.ContentTypes.Overrides[idx].ContentType = contentTypeWorkbook
+			}
+		}
+		return
+	}
+	if content, ok := f.Pkg.Load("[Content_Types].xml"); ok {
+		if b, ok := content.([]byte); ok {
+			f.Pkg.Store("[Content_Types].xml", bytes.ReplaceAll(b, []byte(contentTypeTemplate), []byte(contentTypeWorkbook)))
+		}
+	}
+}
+
+// excel_write_data_sheets writes the sheets structure of read_excel_file or modify_rows
+func excel_write_data_sheets(ctx context.Context, f *excelize.File, data *schema.MethodData, tagStyles ExcelWriteTagStyles, sheetsToRemove map[string]bool) error {
+	sheetsRaw := data.GetConfig("sheets")
+	if sheetsRaw == nil {
+		return nil
+	}
+	dataSheets := ExcelDataSheets{}
+	if err := utils.NewDecoder().Decode(&dataSheets, sheetsRaw); err != nil {
+		return err
+	}
+	for _, sheet := range dataSheets {
+		delete(sheetsToRemove, sheet.Name)
+		f.NewSheet(sheet.Name)
+		if err := excel_write_data_rows(f, sheet.Name, sheet.Rows, tagStyles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func excel_write_data_rows(f *excelize.File, sheetName string, rows ExcelDataRows, tagStyles ExcelWriteTagStyles) error {
+	for _, row := range rows {
+		for _, col := range row.Cols {
+			if col.Col == "" {
+				continue
+			}
+			axis := fmt.Sprintf("%s%d", col.Col, row.Index)
+			if err := f.SetCellValue(sheetName, axis, col.Value); err != nil {
+				return fmt.Errorf("sheet %s row %d col %s: %w", sheetName, row.Index, col.Col, err)
+			}
+			if style, ok := tagStyles.Find(row.Name, col.Tag); ok {
+				if err := f.SetCellStyle(sheetName, axis, axis, style); err != nil {
+					return err
+				}
+			}
+		}
+		for _, child := range row.Children {
+			if err := excel_write_data_rows(f, sheetName, child.Rows, tagStyles); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func excel_define_tag_styles(ctx context.Context, styles map[string]int, data *schema.MethodData) (ExcelWriteTagStyles, error) {
+	tagStyles := ExcelWriteTagStyles{}
+	tagStylesRaw, _ := data.GetConfig("tag-style").([]interface{})
+	for _, t := range tagStylesRaw {
+		ts := t.(map[string]interface{})
+		tagStyle := &ExcelWriteTagStyle{
+			Tag:     ts["tag"].(string),
+			RowType: ts["row-type"].(string),
+		}
+		if style, ok := styles[ts["style"].(string)]; !ok {
+			return nil, fmt.Errorf("tag-style %s uses unknown style %s", tagStyle.Tag, ts["style"])
+		} else {
+			tagStyle.Style = style
+		}
+		var err error
+		if tagStyle.tagRegexp, err = regexp.Compile(tagStyle.Tag); err != nil {
+			return nil, err
+		}
+		if tagStyle.rowRegexp, err = regexp.Compile(tagStyle.RowType); err != nil {
+			return nil, err
+		}
+		tagStyles = append(tagStyles, tagStyle)
+	}
+	return tagStyles, nil
+}
+
 func excel_define_styles(ctx context.Context, f *excelize.File, data *schema.MethodData) (map[string]int, error) {
 	styles := data.GetConfig("style")
 	styleDefs := make(map[string]int)
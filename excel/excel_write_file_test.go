@@ -1,8 +1,13 @@
 package excel
 
 import (
+	"archive/zip"
+	"io"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	excelize "github.com/xuri/excelize/v2"
 	"sbl.systems/go/synwork/plugin-sdk/tunit"
 )
 
@@ -96,3 +101,413 @@ func TestWriteExcelFile02(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestWriteExcelFile03(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test03.xlsx")
+	_defs := `
+	method "write_excel_file" "dum" "join01" {
+		file-name = "` + fileName + `"
+		sheets = $method.sheets
+		tag-style {
+			tag = "^total$"
+			style = "grey"
+		}
+		style {
+			name = "grey"
+			fill {
+				color   = "#888888,#FFFFFF"
+				type    = "gradient"
+				shading = 1
+			}
+		}
+	}
+	`
+	mm := tunit.MethodMock{
+		ProcessorDef: Opts.Provider,
+		InstanceMock: nil,
+		ExecFunc:     excel_write_file,
+		References: map[string]interface{}{
+			"method": map[string]interface{}{
+				"sheets": []interface{}{
+					map[string]interface{}{
+						"name":  "sheet01",
+						"index": 1,
+						"rows": []interface{}{
+							map[string]interface{}{
+								"name":  "order",
+								"index": 1,
+								"cols": []interface{}{
+									map[string]interface{}{"col": "A", "tag": "order", "value": "A-100"},
+									map[string]interface{}{"col": "D", "tag": "total", "value": 30.5},
+								},
+								"children": []interface{}{
+									map[string]interface{}{
+										"name": "item",
+										"rows": []interface{}{
+											map[string]interface{}{
+												"name":  "item",
+												"index": 2,
+												"cols": []interface{}{
+													map[string]interface{}{"col": "B", "tag": "article", "value": "pen"},
+													map[string]interface{}{"col": "D", "tag": "amount", "value": 30.5},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	result := tunit.CallMockMethod(t, mm, _defs)
+	if len(result) != 0 {
+		t.Fatal()
+	}
+	f, err := excelize.OpenFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for axis, want := range map[string]string{"A1": "A-100", "D1": "30.5", "B2": "pen", "D2": "30.5"} {
+		if v, _ := f.GetCellValue("sheet01", axis); v != want {
+			t.Errorf("%s: expected %s, got %s", axis, want, v)
+		}
+	}
+	if style, _ := f.GetCellStyle("sheet01", "D1"); style == 0 {
+		t.Error("tag-style not applied to the total of D1")
+	}
+	if style, _ := f.GetCellStyle("sheet01", "D2"); style != 0 {
+		t.Errorf("tag-style applied to the amount of D2, got style %d", style)
+	}
+}
+
+func TestWriteExcelFile04(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test04.xlsx")
+	_defs := `
+	method "write_excel_file" "dum" "join01" {
+		template-file = "read01.xlsx"
+		file-name = "` + fileName + `"
+		sheet {
+			name = "sheet01"
+			cell { 
+				name = "F1"
+				value = "Total"
+			}
+			cell { 
+				name = "F2"
+				double_value = 219.23
+			}
+		}
+	}
+	`
+	mm := tunit.MethodMock{
+		ProcessorDef: Opts.Provider,
+		InstanceMock: nil,
+		ExecFunc:     excel_write_file,
+		References:   map[string]interface{}{},
+	}
+	result := tunit.CallMockMethod(t, mm, _defs)
+	if len(result) != 0 {
+		t.Fatal()
+	}
+	f, err := excelize.OpenFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := f.GetCellValue("sheet01", "A2"); v != "AA" {
+		t.Fatal("template content not kept")
+	}
+	if v, _ := f.GetCellValue("sheet01", "F1"); v != "Total" {
+		t.Fatal("cell not written")
+	}
+}
+
+func TestWriteExcelFile05(t *testing.T) {
+	dir := t.TempDir()
+	templateName := filepath.Join(dir, "template05.xlsx")
+	fileName := filepath.Join(dir, "test05.xlsx")
+	tpl := excelize.NewFile()
+	tpl.SetCellValue("Sheet1", "A1", "Invoice for {{customer.name}}")
+	tpl.SetCellValue("Sheet1", "A2", "{{#each items}}{{name}}")
+	tpl.SetCellValue("Sheet1", "B2", "{{price}}")
+	tpl.SetCellValue("Sheet1", "A3", "Total")
+	tpl.SetCellValue("Sheet1", "B3", "{{total}}")
+	if err := tpl.SaveAs(templateName); err != nil {
+		t.Fatal(err)
+	}
+	_defs := `
+	method "write_excel_file" "dum" "join01" {
+		template-file = "` + templateName + `"
+		file-name = "` + fileName + `"
+		values = $method.values
+	}
+	`
+	mm := tunit.MethodMock{
+		ProcessorDef: Opts.Provider,
+		InstanceMock: nil,
+		ExecFunc:     excel_write_file,
+		References: map[string]interface{}{
+			"method": map[string]interface{}{
+				"values": map[string]interface{}{
+					"customer": map[string]interface{}{"name": "ACME"},
+					"items": []interface{}{
+						map[string]interface{}{"name": "pen", "price": 1.5},
+						map[string]interface{}{"name": "ink", "price": 3.0},
+					},
+					"total": 4.5,
+				},
+			},
+		},
+	}
+	tunit.CallMockMethod(t, mm, _defs)
+	f, err := excelize.OpenFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, _ := f.GetRows("Sheet1")
+	if len(rows) != 4 || rows[0][0] != "Invoice for ACME" || rows[2][0] != "ink" || rows[3][1] != "4.5" {
+		t.Fatalf("invalid placeholder result %v", rows)
+	}
+}
+
+func TestWriteExcelFileFormula(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "formula.xlsx")
+	f := excelize.NewFile()
+	testWriteRows(t, f, "Sheet1", [][]interface{}{{1, 2}, {3, 4}, {5, 6}})
+	for _, cell := range []map[string]interface{}{
+		{"name": "C1", "formula": "=A1*B1", "formula-type": "shared", "formula-ref": "C1:C3"},
+		{"name": "A4", "formula": "SUM(A1:A3)", "formula-type": "normal", "cached-value": 9},
+		{"name": "D1", "formula": "SUM(A1:A3*B1:B3)", "formula-type": "array"},
+	} {
+		if err := excel_write_formula(f, "Sheet1", cell["name"].(string), cell); err != nil {
+			t.Fatal(err)
+		}
+	}
+	excel_write_full_calc(f)
+	if err := f.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for axis, want := range map[string]string{"C1": "A1*B1", "C3": "A3*B3", "A4": "SUM(A1:A3)", "D1": "SUM(A1:A3*B1:B3)"} {
+		if formula, err := f.GetCellFormula("Sheet1", axis); err != nil {
+			t.Fatal(err)
+		} else if formula != want {
+			t.Errorf("%s: expected formula %s, got %s", axis, want, formula)
+		}
+	}
+	if value, _ := f.GetCellValue("Sheet1", "A4"); value != "9" {
+		t.Errorf("cached value not written, got %q", value)
+	}
+	if value, err := f.CalcCellValue("Sheet1", "C3"); err != nil || value != "30" {
+		t.Errorf("shared formula calculated %q: %v", value, err)
+	}
+	if !f.WorkBook.CalcPr.FullCalcOnLoad {
+		t.Error("full calculation on load not set")
+	}
+	withoutCalcPr := excelize.NewFile()
+	withoutCalcPr.GetSheetList()
+	withoutCalcPr.WorkBook.CalcPr = nil
+	excel_write_full_calc(withoutCalcPr)
+	if withoutCalcPr.WorkBook.CalcPr == nil || !withoutCalcPr.WorkBook.CalcPr.FullCalcOnLoad {
+		t.Error("full calculation on load not set without calcPr")
+	}
+	for _, cell := range []map[string]interface{}{
+		{"formula": "A1", "formula-type": "shared"},
+		{"formula": "A1", "formula-type": "shared", "formula-ref": "C2:C3"},
+		{"formula": "A1", "formula-type": "normal", "formula-ref": "C1:C3"},
+		{"formula": "A1", "formula-type": "table"},
+	} {
+		if err := excel_write_formula(f, "Sheet1", "C1", cell); err == nil {
+			t.Errorf("invalid formula %v accepted", cell)
+		}
+	}
+}
+
+func TestWriteExcelFileLayout(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "layout.xlsx")
+	f := excelize.NewFile()
+	big, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Size: 22}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	styles := map[string]int{"big": big}
+	sheet := map[string]interface{}{
+		"name":     "Sheet1",
+		"auto-fit": true,
+		"column": []interface{}{
+			map[string]interface{}{"range": "A", "width": 30.0},
+			map[string]interface{}{"range": "D:E", "hidden": true, "outline-level": 2},
+		},
+		"row": []interface{}{
+			map[string]interface{}{"index": 2, "height": 40.0, "style": "big"},
+			map[string]interface{}{"index": 3, "hidden": true},
+		},
+	}
+	if err := excel_write_columns(f, "Sheet1", sheet, styles); err != nil {
+		t.Fatal(err)
+	}
+	for axis, value := range map[string]string{"A1": "a", "B1": "short", "C1": "a much longer content", "B2": "short"} {
+		if err := f.SetCellValue("Sheet1", axis, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := excel_write_layout(f, "Sheet1", sheet, styles); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = excelize.OpenFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if width, _ := f.GetColWidth("Sheet1", "A"); width != 30 {
+		t.Errorf("column A: expected width 30, got %v", width)
+	}
+	widthB, _ := f.GetColWidth("Sheet1", "B")
+	widthC, _ := f.GetColWidth("Sheet1", "C")
+	if widthB <= 5*22.0/11 || widthC <= widthB {
+		t.Errorf("auto-fit widths B %v C %v", widthB, widthC)
+	}
+	for _, col := range []string{"D", "E"} {
+		if visible, _ := f.GetColVisible("Sheet1", col); visible {
+			t.Errorf("column %s not hidden", col)
+		}
+		if level, _ := f.GetColOutlineLevel("Sheet1", col); level != 2 {
+			t.Errorf("column %s: expected outline level 2, got %d", col, level)
+		}
+	}
+	if height, _ := f.GetRowHeight("Sheet1", 2); height != 40 {
+		t.Errorf("row 2: expected height 40, got %v", height)
+	}
+	if visible, _ := f.GetRowVisible("Sheet1", 3); visible {
+		t.Error("row 3 not hidden")
+	}
+	if style, _ := f.GetCellStyle("Sheet1", "B2"); style != big {
+		t.Errorf("row style not applied to B2, got %d", style)
+	}
+	for _, invalid := range []map[string]interface{}{
+		{"column": []interface{}{map[string]interface{}{"range": "A:B:C"}}},
+		{"column": []interface{}{map[string]interface{}{"range": "A", "hidden": true, "style": "missing"}}},
+		{"column": []interface{}{map[string]interface{}{"range": "A", "width": 5.0, "hidden": true, "outline-level": 8}}},
+	} {
+		if err := excel_write_columns(f, "Sheet1", invalid, styles); err == nil {
+			t.Errorf("invalid column %v accepted", invalid)
+		}
+	}
+	width, _ := f.GetColWidth("Sheet1", "A")
+	if visible, _ := f.GetColVisible("Sheet1", "A"); width != 30 || !visible {
+		t.Errorf("invalid column blocks changed column A, width %v visible %v", width, visible)
+	}
+	if err := excel_write_layout(f, "Sheet1", map[string]interface{}{"row": []interface{}{map[string]interface{}{"index": 0}}}, styles); err == nil {
+		t.Error("row index 0 accepted")
+	}
+}
+
+func TestWriteExcelFileTables(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "tables.xlsx")
+	f := excelize.NewFile()
+	f.NewSheet("Notes")
+	for _, sheetName := range []string{"Sheet1", "Notes"} {
+		testWriteRows(t, f, sheetName, [][]interface{}{{"Order", "Amount"}, {"A-1", 10}, {"A-2", 20}})
+	}
+	sheets := []interface{}{
+		map[string]interface{}{
+			"name":   "Sheet1",
+			"freeze": map[string]interface{}{"rows": 1, "cols": 0},
+			"table": []interface{}{map[string]interface{}{
+				"name": "Orders", "range": "A1:B3", "style": "TableStyleLight9",
+				"banded-rows": true, "banded-columns": false, "first-column": false, "last-column": false,
+			}},
+		},
+		map[string]interface{}{
+			"name":        "Notes",
+			"auto-filter": map[string]interface{}{"range": "A1:B3"},
+		},
+	}
+	if err := excel_write_tables_validate(f, sheets); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range sheets {
+		sheet := s.(map[string]interface{})
+		if err := excel_write_tables(f, sheet["name"].(string), sheet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.SaveAs(fileName); err != nil {
+		t.Fatal(err)
+	}
+	content := map[string]string{}
+	archive, err := zip.OpenReader(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	for _, file := range archive.File {
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content[file.Name] = string(b)
+	}
+	for path, want := range map[string][]string{
+		"xl/worksheets/sheet1.xml": {`state="frozen"`, `ySplit="1"`, `topLeftCell="A2"`, `<tablePart`},
+		"xl/worksheets/sheet2.xml": {`<autoFilter ref="$A$1:$B$3"`},
+		"xl/tables/table1.xml":     {`name="Orders"`, `ref="A1:B3"`, `name="TableStyleLight9"`, `showRowStripes="true"`},
+	} {
+		for _, w := range want {
+			if !strings.Contains(content[path], w) {
+				t.Errorf("%s: %s not found", path, w)
+			}
+		}
+	}
+	if f, err = excelize.OpenFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	table := func(name, rng string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "range": rng}
+	}
+	for _, tc := range []struct {
+		sheets  []interface{}
+		problem string
+	}{
+		{[]interface{}{map[string]interface{}{"name": "New", "table": []interface{}{table("orders", "D1:E3")}}}, "already used"},
+		{[]interface{}{
+			map[string]interface{}{"name": "A", "table": []interface{}{table("Sales", "A1:B3")}},
+			map[string]interface{}{"name": "B", "table": []interface{}{table("sales", "A1:B3")}},
+		}, "already used"},
+		{[]interface{}{map[string]interface{}{"name": "A", "table": []interface{}{table("Sales", "A1:B3"), table("Costs", "B3:C5")}}}, "overlaps table Sales"},
+		{[]interface{}{map[string]interface{}{"name": "Sheet1", "table": []interface{}{table("Sales", "B3:C5")}}}, "overlaps table Orders"},
+		{[]interface{}{map[string]interface{}{"name": "A", "auto-filter": map[string]interface{}{"range": "A1:C1"}, "table": []interface{}{table("Sales", "B1:C3")}}}, "overlaps the auto-filter"},
+		{[]interface{}{map[string]interface{}{"name": "A", "table": []interface{}{table("1st", "A1:B3")}}}, "invalid name"},
+		{[]interface{}{map[string]interface{}{"name": "A", "table": []interface{}{table("AB12", "A1:B3")}}}, "cell reference"},
+		{[]interface{}{map[string]interface{}{"name": "A", "table": []interface{}{table("Sales", "A1:B1")}}}, "data row"},
+		{[]interface{}{map[string]interface{}{"name": "A", "freeze": map[string]interface{}{"rows": -1, "cols": 0}}}, "freeze"},
+	} {
+		if err := excel_write_tables_validate(f, tc.sheets); err == nil || !strings.Contains(err.Error(), tc.problem) {
+			t.Errorf("expected problem %s, got %v", tc.problem, err)
+		}
+	}
+	if err := excel_write_tables_validate(f, []interface{}{
+		map[string]interface{}{"name": "A", "table": []interface{}{table("Sales", "A1:B3")}},
+		map[string]interface{}{"name": "B", "table": []interface{}{table("Costs", "A1:B3")}},
+	}); err != nil {
+		t.Errorf("tables of different sheets rejected: %v", err)
+	}
+	testWriteRows(t, f, "Notes", [][]interface{}{{"Order", "Amount", "Item", "item"}})
+	for rng, problem := range map[string]string{"C1:D3": `repeats "item" of C1`, "D1:E3": "E1 is empty"} {
+		sheet := map[string]interface{}{"table": []interface{}{table("Items", rng)}}
+		if err := excel_write_tables(f, "Notes", sheet); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("table %s: expected problem %s, got %v", rng, problem, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package excel
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+// excel_write_formula writes the formula of a cell block. A shared formula is written to
+// formula-ref relative to the cell, an array formula fills formula-ref (default the cell).
+// cached-value is written as value of the cell for viewers which don't recalculate.
+func excel_write_formula(f *excelize.File, sheetName, cellName string, cell map[string]interface{}) error {
+	formula := strings.TrimPrefix(strings.TrimSpace(cell["formula"].(string)), "=")
+	formulaType, _ := cell["formula-type"].(string)
+	ref, _ := cell["formula-ref"].(string)
+	opts := []excelize.FormulaOpts{}
+	switch formulaType {
+	case "", excelize.STCellFormulaTypeNormal:
+		if ref != "" {
+			return fmt.Errorf("cell %s: formula-ref requires formula-type shared or array", cellName)
+		}
+	case excelize.STCellFormulaTypeShared, excelize.STCellFormulaTypeArray:
+		if ref == "" && formulaType == excelize.STCellFormulaTypeShared {
+			return fmt.Errorf("cell %s: shared formula requires formula-ref", cellName)
+		} else if ref == "" {
+			ref = cellName + ":" + cellName
+		}
+		if err := excel_write_formula_ref(cellName, ref); err != nil {
+			return err
+		}
+		typ := formulaType
+		opts = append(opts, excelize.FormulaOpts{Type: &typ, Ref: &ref})
+	default:
+		return fmt.Errorf("cell %s: unknown formula-type %s, expected normal, shared or array", cellName, formulaType)
+	}
+	if cached := cell["cached-value"]; cached != nil {
+		if err := f.SetCellValue(sheetName, cellName, cached); err != nil {
+			return err
+		}
+	}
+	if err := f.SetCellFormula(sheetName, cellName, formula, opts...); err != nil {
+		return fmt.Errorf("cell %s: %w", cellName, err)
+	}
+	return nil
+}
+
+// excel_write_formula_ref checks that the range of a shared or array formula starts at the cell
+func excel_write_formula_ref(cellName, ref string) error {
+	parts := strings.Split(ref, ":")
+	if len(parts) != 2 {
+		return fmt.Errorf("cell %s: invalid formula-ref %s", cellName, ref)
+	}
+	col, row, err := excelize.CellNameToCoordinates(cellName)
+	if err != nil {
+		return err
+	}
+	startCol, startRow, err := excelize.CellNameToCoordinates(parts[0])
+	if err != nil {
+		return fmt.Errorf("cell %s: invalid formula-ref %s: %w", cellName, ref, err)
+	}
+	endCol, endRow, err := excelize.CellNameToCoordinates(parts[1])
+	if err != nil {
+		return fmt.Errorf("cell %s: invalid formula-ref %s: %w", cellName, ref, err)
+	}
+	if startCol != col || startRow != row || endCol < startCol || endRow < startRow {
+		return fmt.Errorf("cell %s: formula-ref %s has to start at the cell", cellName, ref)
+	}
+	return nil
+}
+
+// excel_write_full_calc lets excel recalculate all formulas when the workbook is opened,
+// a workbook without calcPr gets one
+func excel_write_full_calc(f *excelize.File) {
+	// the workbook is read on first use
+	f.GetSheetList()
+	if f.WorkBook == nil {
+		return
+	}
+	if f.WorkBook.CalcPr == nil {
+		// excelize doesn't export the type of calcPr, create it from the field type
+		calcPr := reflect.ValueOf(f.WorkBook).Elem().FieldByName("CalcPr")
+		calcPr.Set(reflect.New(calcPr.Type().Elem()))
+	}
+	f.WorkBook.CalcPr.FullCalcOnLoad = true
+}
@@ -0,0 +1,256 @@
+package excel
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	excelize "github.com/xuri/excelize/v2"
+	"sbl.systems/go/synwork/plugin-sdk/schema"
+)
+
+const (
+	layoutDefaultFontSize = 11.0
+	layoutMaxColWidth     = 255.0
+)
+
+var (
+	excel_column = map[string]*schema.Schema{
+		"range":         {Type: schema.TypeString, Required: true, DefaultValue: ""},
+		"width":         {Type: schema.TypeFloat, Optional: true, DefaultValue: 0.0},
+		"hidden":        {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		"outline-level": {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
+		"style":         {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+	}
+	excel_row = map[string]*schema.Schema{
+		"index":  {Type: schema.TypeInt, Required: true},
+		"height": {Type: schema.TypeFloat, Optional: true, DefaultValue: 0.0},
+		"hidden": {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		"style":  {Type: schema.TypeString, Optional: true, DefaultValue: ""},
+	}
+)
+
+// excel_write_columns applies the column blocks of a sheet. It runs before the cells are
+// written, so new cells get the column style while the style of a cell block still wins.
+// A block is checked completely before any of its settings is applied.
+func excel_write_columns(f *excelize.File, sheetName string, sheet map[string]interface{}, styles map[string]int) error {
+	columns, _ := sheet["column"].([]interface{})
+	for _, c := range columns {
+		column := c.(map[string]interface{})
+		rng, _ := column["range"].(string)
+		start, end, err := excel_write_col_range(rng)
+		if err != nil {
+			return fmt.Errorf("sheet %s column %s: %w", sheetName, rng, err)
+		}
+		styleName, _ := column["style"].(string)
+		style, ok := styles[styleName]
+		if styleName != "" && !ok {
+			return fmt.Errorf("sheet %s column %s uses unknown style %s", sheetName, rng, styleName)
+		}
+		level, _ := column["outline-level"].(int)
+		if level < 0 || level > 7 {
+			return fmt.Errorf("sheet %s column %s: outline-level %d has to be between 0 and 7", sheetName, rng, level)
+		}
+		if styleName != "" {
+			if err := f.SetColStyle(sheetName, start+":"+end, style); err != nil {
+				return fmt.Errorf("sheet %s column %s: %w", sheetName, rng, err)
+			}
+		}
+		if width, _ := column["width"].(float64); width > 0 {
+			if err := f.SetColWidth(sheetName, start, end, width); err != nil {
+				return fmt.Errorf("sheet %s column %s: %w", sheetName, rng, err)
+			}
+		}
+		if hidden, _ := column["hidden"].(bool); hidden {
+			if err := f.SetColVisible(sheetName, start+":"+end, false); err != nil {
+				return fmt.Errorf("sheet %s column %s: %w", sheetName, rng, err)
+			}
+		}
+		if level != 0 {
+			from, _ := excelize.ColumnNameToNumber(start)
+			to, _ := excelize.ColumnNameToNumber(end)
+			for col := from; col <= to; col++ {
+				name, _ := excelize.ColumnNumberToName(col)
+				if err := f.SetColOutlineLevel(sheetName, name, uint8(level)); err != nil {
+					return fmt.Errorf("sheet %s column %s: %w", sheetName, rng, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// excel_write_layout applies the row blocks and auto-fit of a sheet after all content is
+// written. Columns with a width in a column block are not auto-fitted.
+func excel_write_layout(f *excelize.File, sheetName string, sheet map[string]interface{}, styles map[string]int) error {
+	rows, _ := sheet["row"].([]interface{})
+	for _, r := range rows {
+		row := r.(map[string]interface{})
+		index, _ := row["index"].(int)
+		if index < 1 {
+			return fmt.Errorf("sheet %s row %d: index has to start with 1", sheetName, index)
+		}
+		if height, _ := row["height"].(float64); height > 0 {
+			if err := f.SetRowHeight(sheetName, index, height); err != nil {
+				return fmt.Errorf("sheet %s row %d: %w", sheetName, index, err)
+			}
+		}
+		if hidden, _ := row["hidden"].(bool); hidden {
+			if err := f.SetRowVisible(sheetName, index, false); err != nil {
+				return fmt.Errorf("sheet %s row %d: %w", sheetName, index, err)
+			}
+		}
+		if styleName, _ := row["style"].(string); styleName != "" {
+			style, ok := styles[styleName]
+			if !ok {
+				return fmt.Errorf("sheet %s row %d uses unknown style %s", sheetName, index, styleName)
+			}
+			if err := excel_write_row_style(f, sheetName, index, style); err != nil {
+				return fmt.Errorf("sheet %s row %d: %w", sheetName, index, err)
+			}
+		}
+	}
+	if autoFit, _ := sheet["auto-fit"].(bool); autoFit {
+		fixed := map[int]bool{}
+		columns, _ := sheet["column"].([]interface{})
+		for _, c := range columns {
+			column := c.(map[string]interface{})
+			if width, _ := column["width"].(float64); width > 0 {
+				start, end, _ := excel_write_col_range(column["range"].(string))
+				from, _ := excelize.ColumnNameToNumber(start)
+				to, _ := excelize.ColumnNameToNumber(end)
+				for col := from; col <= to; col++ {
+					fixed[col] = true
+				}
+			}
+		}
+		if err := excel_write_auto_fit(f, sheetName, fixed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// excel_write_row_style sets the style of the row and of its written cells without a style,
+// excel doesn't apply the row style to cells which exist already
+func excel_write_row_style(f *excelize.File, sheetName string, index, style int) error {
+	if err := f.SetRowStyle(sheetName, index, index, style); err != nil {
+		return err
+	}
+	rows, err := f.GetRows(sheetName)
+	if err != nil || len(rows) < index {
+		return err
+	}
+	for col := range rows[index-1] {
+		axis, _ := excelize.CoordinatesToCellName(col+1, index)
+		if current, err := f.GetCellStyle(sheetName, axis); err != nil {
+			return err
+		} else if current == 0 {
+			if err := f.SetCellStyle(sheetName, axis, axis, style); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// excel_write_auto_fit estimates the width of the columns from the longest line of their
+// cells scaled by the font size, merged cells are left out
+func excel_write_auto_fit(f *excelize.File, sheetName string, fixed map[int]bool) error {
+	rows, err := f.GetRows(sheetName)
+	if err != nil {
+		return err
+	}
+	mergeCells, err := f.GetMergeCells(sheetName)
+	if err != nil {
+		return err
+	}
+	merged := map[string]bool{}
+	for _, mergeCell := range mergeCells {
+		startCol, startRow, _ := excelize.CellNameToCoordinates(mergeCell.GetStartAxis())
+		endCol, endRow, _ := excelize.CellNameToCoordinates(mergeCell.GetEndAxis())
+		for row := startRow; row <= endRow; row++ {
+			for col := startCol; col <= endCol; col++ {
+				axis, _ := excelize.CoordinatesToCellName(col, row)
+				merged[axis] = true
+			}
+		}
+	}
+	fontSizes := map[int]float64{}
+	widths := map[int]float64{}
+	for rowIdx, row := range rows {
+		for colIdx, value := range row {
+			if value == "" || fixed[colIdx+1] {
+				continue
+			}
+			axis, _ := excelize.CoordinatesToCellName(colIdx+1, rowIdx+1)
+			if merged[axis] {
+				continue
+			}
+			style, err := f.GetCellStyle(sheetName, axis)
+			if err != nil {
+				return err
+			}
+			size, ok := fontSizes[style]
+			if !ok {
+				size = excel_write_font_size(f, style)
+				fontSizes[style] = size
+			}
+			length := 0
+			for _, line := range strings.Split(value, "\n") {
+				if l := utf8.RuneCountInString(line); l > length {
+					length = l
+				}
+			}
+			if width := float64(length)*size/layoutDefaultFontSize + 2; width > widths[colIdx+1] {
+				widths[colIdx+1] = width
+			}
+		}
+	}
+	for col, width := range widths {
+		name, _ := excelize.ColumnNumberToName(col)
+		if width > layoutMaxColWidth {
+			width = layoutMaxColWidth
+		}
+		if err := f.SetColWidth(sheetName, name, name, width); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// excel_write_font_size returns the font size of a style, default 11
+func excel_write_font_size(f *excelize.File, style int) float64 {
+	if f.Styles == nil || f.Styles.CellXfs == nil || style >= len(f.Styles.CellXfs.Xf) {
+		return layoutDefaultFontSize
+	}
+	fontID := f.Styles.CellXfs.Xf[style].FontID
+	if fontID == nil || f.Styles.Fonts == nil || *fontID >= len(f.Styles.Fonts.Font) {
+		return layoutDefaultFontSize
+	}
+	if font := f.Styles.Fonts.Font[*fontID]; font != nil && font.Sz != nil && font.Sz.Val != nil && *font.Sz.Val > 0 {
+		return *font.Sz.Val
+	}
+	return layoutDefaultFontSize
+}
+
+// excel_write_col_range splits a column range like A or A:C into its first and last column
+func excel_write_col_range(rng string) (string, string, error) {
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(rng)), ":")
+	if len(parts) > 2 {
+		return "", "", fmt.Errorf("invalid range %s", rng)
+	}
+	start, end := parts[0], parts[len(parts)-1]
+	from, err := excelize.ColumnNameToNumber(start)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid range %s: %w", rng, err)
+	}
+	to, err := excelize.ColumnNameToNumber(end)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid range %s: %w", rng, err)
+	}
+	if to < from {
+		start, end = end, start
+	}
+	return start, end, nil
+}
@@ -0,0 +1,333 @@
+package excel
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	excelize "github.com/xuri/excelize/v2"
+	"sbl.systems/go/synwork/plugin-sdk/schema"
+)
+
+var (
+	excel_freeze = map[string]*schema.Schema{
+		"rows": {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
+		"cols": {Type: schema.TypeInt, Optional: true, DefaultValue: 0},
+	}
+	excel_auto_filter = map[string]*schema.Schema{
+		"range": {Type: schema.TypeString, Required: true, DefaultValue: ""},
+	}
+	excel_table = map[string]*schema.Schema{
+		"name":           {Type: schema.TypeString, Required: true, DefaultValue: ""},
+		"range":          {Type: schema.TypeString, Required: true, DefaultValue: ""},
+		"style":          {Type: schema.TypeString, Optional: true, DefaultValue: "TableStyleMedium2"},
+		"banded-rows":    {Type: schema.TypeBool, Optional: true, DefaultValue: true},
+		"banded-columns": {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		"first-column":   {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+		"last-column":    {Type: schema.TypeBool, Optional: true, DefaultValue: false},
+	}
+	tableNameRegexp = regexp.MustCompile(`^[A-Za-z_\\][A-Za-z0-9_.\\]*$`)
+)
+
+type (
+	// writeArea is a cell range like A1:D10, start and end are sorted
+	writeArea struct {
+		Ref                                string
+		StartCol, StartRow, EndCol, EndRow int
+	}
+	// writeTable is a table block of a sheet after validation
+	writeTable struct {
+		Sheet string
+		Name  string
+		Area  *writeArea
+	}
+)
+
+// Overlaps checks whether the areas share at least one cell
+func (a *writeArea) Overlaps(o *writeArea) bool {
+	return a.StartCol <= o.EndCol && o.StartCol <= a.EndCol && a.StartRow <= o.EndRow && o.StartRow <= a.EndRow
+}
+
+// excel_write_area parses a cell range like A1:D10
+func excel_write_area(rng string) (*writeArea, error) {
+	parts := strings.Split(strings.TrimSpace(rng), ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid range %s, expected a range like A1:D10", rng)
+	}
+	area := &writeArea{}
+	var err error
+	if area.StartCol, area.StartRow, err = excelize.CellNameToCoordinates(parts[0]); err != nil {
+		return nil, fmt.Errorf("invalid range %s: %w", rng, err)
+	}
+	if area.EndCol, area.EndRow, err = excelize.CellNameToCoordinates(parts[1]); err != nil {
+		return nil, fmt.Errorf("invalid range %s: %w", rng, err)
+	}
+	if area.EndCol < area.StartCol {
+		area.StartCol, area.EndCol = area.EndCol, area.StartCol
+	}
+	if area.EndRow < area.StartRow {
+		area.StartRow, area.EndRow = area.EndRow, area.StartRow
+	}
+	start, _ := excelize.CoordinatesToCellName(area.StartCol, area.StartRow)
+	end, _ := excelize.CoordinatesToCellName(area.EndCol, area.EndRow)
+	area.Ref = start + ":" + end
+	return area, nil
+}
+
+// excel_write_tables_validate checks the freeze, auto-filter and table blocks of all sheet
+// blocks before anything is written. Table names have to be unique in the workbook, including
+// the tables and defined names of a template, tables of a sheet must not overlap each other,
+// the tables of the template or the auto-filter of the sheet.
+func excel_write_tables_validate(f *excelize.File, sheets []interface{}) error {
+	names, tables, err := excel_write_existing_tables(f)
+	if err != nil {
+		return err
+	}
+	for _, s := range sheets {
+		sheet := s.(map[string]interface{})
+		sheetName := sheet["name"].(string)
+		if freeze, ok := sheet["freeze"].(map[string]interface{}); ok {
+			rows, _ := freeze["rows"].(int)
+			cols, _ := freeze["cols"].(int)
+			if rows < 0 || cols < 0 || rows >= excelize.TotalRows || cols >= excelize.TotalColumns {
+				return fmt.Errorf("sheet %s freeze: invalid rows %d or cols %d", sheetName, rows, cols)
+			}
+		}
+		var filter *writeArea
+		if autoFilter, ok := sheet["auto-filter"].(map[string]interface{}); ok {
+			if filter, err = excel_write_area(autoFilter["range"].(string)); err != nil {
+				return fmt.Errorf("sheet %s auto-filter: %w", sheetName, err)
+			}
+		}
+		tablesRaw, _ := sheet["table"].([]interface{})
+		for idx, t := range tablesRaw {
+			table := t.(map[string]interface{})
+			name, _ := table["name"].(string)
+			if !tableNameRegexp.MatchString(name) || len(name) > 255 {
+				return fmt.Errorf("sheet %s table[%d]: invalid name %q, use letters, digits, _ and . starting with a letter or _", sheetName, idx, name)
+			} else if _, _, err := excelize.CellNameToCoordinates(name); err == nil {
+				return fmt.Errorf("sheet %s table[%d]: name %s is a cell reference", sheetName, idx, name)
+			}
+			if other, ok := names[strings.ToLower(name)]; ok {
+				return fmt.Errorf("sheet %s table[%d]: name %s is already used by %s", sheetName, idx, name, other)
+			}
+			names[strings.ToLower(name)] = fmt.Sprintf("sheet %s table %s", sheetName, name)
+			area, err := excel_write_area(table["range"].(string))
+			if err != nil {
+				return fmt.Errorf("sheet %s table[%d]: %w", sheetName, idx, err)
+			} else if area.EndRow == area.StartRow {
+				return fmt.Errorf("sheet %s table[%d]: range %s needs a header and at least one data row", sheetName, idx, area.Ref)
+			}
+			if filter != nil && filter.Overlaps(area) {
+				return fmt.Errorf("sheet %s table[%d]: range %s overlaps the auto-filter %s", sheetName, idx, area.Ref, filter.Ref)
+			}
+			for _, other := range tables {
+				if other.Sheet == sheetName && other.Area.Overlaps(area) {
+					return fmt.Errorf("sheet %s table[%d]: range %s overlaps table %s %s", sheetName, idx, area.Ref, other.Name, other.Area.Ref)
+				}
+			}
+			tables = append(tables, &writeTable{Sheet: sheetName, Name: name, Area: area})
+		}
+	}
+	return nil
+}
+
+// excel_write_existing_tables collects the lower case names of the tables and defined names
+// in the workbook and the tables of the template with their sheet
+func excel_write_existing_tables(f *excelize.File) (map[string]string, []*writeTable, error) {
+	names := map[string]string{}
+	for _, definedName := range f.GetDefinedName() {
+		names[strings.ToLower(definedName.Name)] = "defined name " + definedName.Name
+	}
+	byFile := map[string]*writeTable{}
+	var err error
+	f.Pkg.Range(func(k, v interface{}) bool {
+		file, _ := k.(string)
+		content, ok := v.([]byte)
+		if !ok || !strings.HasPrefix(file, "xl/tables/") || !strings.HasSuffix(file, ".xml") {
+			return true
+		}
+		table := struct {
+			Name        string `xml:"name,attr"`
+			DisplayName string `xml:"displayName,attr"`
+			Ref         string `xml:"ref,attr"`
+		}{}
+		if err = xml.Unmarshal(content, &table); err != nil {
+			err = fmt.Errorf("%s: %w", file, err)
+			return false
+		}
+		for _, name := range []string{table.Name, table.DisplayName} {
+			if name != "" {
+				names[strings.ToLower(name)] = "table " + name + " of the template"
+			}
+		}
+		existing := &writeTable{Name: table.DisplayName}
+		if existing.Name == "" {
+			existing.Name = table.Name
+		}
+		if existing.Area, err = excel_write_area(table.Ref); err != nil {
+			err = fmt.Errorf("%s: %w", file, err)
+			return false
+		}
+		byFile[file] = existing
+		return true
+	})
+	tables := []*writeTable{}
+	if err != nil || len(byFile) == 0 || f.WorkBook == nil {
+		return names, tables, err
+	}
+	// the sheet of a table is found by the relationships of the workbook and of the sheets
+	sheetFiles, err := excel_write_relationships(f, "xl/_rels/workbook.xml.rels")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, sheet := range f.WorkBook.Sheets.Sheet {
+		sheetFile, ok := sheetFiles[sheet.ID]
+		if !ok {
+			continue
+		}
+		targets, err := excel_write_relationships(f, path.Join(path.Dir(sheetFile), "_rels", path.Base(sheetFile)+".rels"))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, target := range targets {
+			if table, ok := byFile[target]; ok {
+				table.Sheet = sheet.Name
+				tables = append(tables, table)
+			}
+		}
+	}
+	return names, tables, nil
+}
+
+// excel_write_relationships returns the package paths of the targets of a relationships part
+// by their id, a missing part has no relationships
+func excel_write_relationships(f *excelize.File, relsFile string) (map[string]string, error) {
+	targets := map[string]string{}
+	v, ok := f.Pkg.Load(relsFile)
+	if !ok {
+		return targets, nil
+	}
+	content, _ := v.([]byte)
+	rels := struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}{}
+	if err := xml.Unmarshal(content, &rels); err != nil {
+		return nil, fmt.Errorf("%s: %w", relsFile, err)
+	}
+	// targets are relative to the directory of the part owning the relationships
+	dir := path.Dir(path.Dir(relsFile))
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join(dir, rel.Target)
+		}
+	}
+	return targets, nil
+}
+
+// excel_write_tables writes the freeze, auto-filter and table blocks of a validated sheet
+// block after its content, the header row of a table is taken from the written cells
+func excel_write_tables(f *excelize.File, sheetName string, sheet map[string]interface{}) error {
+	if freeze, ok := sheet["freeze"].(map[string]interface{}); ok {
+		rows, _ := freeze["rows"].(int)
+		cols, _ := freeze["cols"].(int)
+		if err := excel_write_freeze(f, sheetName, rows, cols); err != nil {
+			return fmt.Errorf("sheet %s freeze: %w", sheetName, err)
+		}
+	}
+	if autoFilter, ok := sheet["auto-filter"].(map[string]interface{}); ok {
+		area, err := excel_write_area(autoFilter["range"].(string))
+		if err != nil {
+			return fmt.Errorf("sheet %s auto-filter: %w", sheetName, err)
+		}
+		start, end, _ := strings.Cut(area.Ref, ":")
+		if err := f.AutoFilter(sheetName, start, end, ""); err != nil {
+			return fmt.Errorf("sheet %s auto-filter: %w", sheetName, err)
+		}
+	}
+	tables, _ := sheet["table"].([]interface{})
+	for idx, t := range tables {
+		table := t.(map[string]interface{})
+		area, err := excel_write_area(table["range"].(string))
+		if err != nil {
+			return fmt.Errorf("sheet %s table[%d]: %w", sheetName, idx, err)
+		}
+		format, err := json.Marshal(map[string]interface{}{
+			"table_name":          table["name"],
+			"table_style":         table["style"],
+			"show_row_stripes":    table["banded-rows"],
+			"show_column_stripes": table["banded-columns"],
+			"show_first_column":   table["first-column"],
+			"show_last_column":    table["last-column"],
+		})
+		if err != nil {
+			return err
+		}
+		if err := excel_write_table_header(f, sheetName, area); err != nil {
+			return fmt.Errorf("sheet %s table[%d]: %w", sheetName, idx, err)
+		}
+		start, end, _ := strings.Cut(area.Ref, ":")
+		if err := f.AddTable(sheetName, start, end, string(format)); err != nil {
+			return fmt.Errorf("sheet %s table[%d]: %w", sheetName, idx, err)
+		}
+	}
+	return nil
+}
+
+// excel_write_table_header checks the header row of a table, excel requires a unique and
+// not empty name for every column
+func excel_write_table_header(f *excelize.File, sheetName string, area *writeArea) error {
+	headers := map[string]string{}
+	for col := area.StartCol; col <= area.EndCol; col++ {
+		axis, _ := excelize.CoordinatesToCellName(col, area.StartRow)
+		value, err := f.GetCellValue(sheetName, axis)
+		if err != nil {
+			return err
+		}
+		header := strings.ToLower(strings.TrimSpace(value))
+		if header == "" {
+			return fmt.Errorf("header cell %s is empty", axis)
+		} else if other, ok := headers[header]; ok {
+			return fmt.Errorf("header cell %s repeats %q of %s", axis, value, other)
+		}
+		headers[header] = axis
+	}
+	return nil
+}
+
+// excel_write_freeze freezes the first rows and cols of the sheet, 0 and 0 removes the panes
+func excel_write_freeze(f *excelize.File, sheetName string, rows, cols int) error {
+	if rows == 0 && cols == 0 {
+		return f.SetPanes(sheetName, `{"freeze":false,"split":false}`)
+	}
+	topLeft, err := excelize.CoordinatesToCellName(cols+1, rows+1)
+	if err != nil {
+		return err
+	}
+	pane := "bottomRight"
+	if cols == 0 {
+		pane = "bottomLeft"
+	} else if rows == 0 {
+		pane = "topRight"
+	}
+	panes, err := json.Marshal(map[string]interface{}{
+		"freeze":        true,
+		"x_split":       cols,
+		"y_split":       rows,
+		"top_left_cell": topLeft,
+		"active_pane":   pane,
+		"panes":         []map[string]string{{"sqref": topLeft, "active_cell": topLeft, "pane": pane}},
+	})
+	if err != nil {
+		return err
+	}
+	return f.SetPanes(sheetName, string(panes))
+}
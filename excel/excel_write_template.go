@@ -0,0 +1,185 @@
+package excel
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+var (
+	placeholderRegexp     = regexp.MustCompile(`\{\{\s*([^{}#/\s]+)\s*\}\}`)
+	placeholderEachRegexp = regexp.MustCompile(`\{\{#each\s+([^{}\s]+)\s*\}\}`)
+	placeholderEndRegexp  = regexp.MustCompile(`\{\{/each\s*\}\}`)
+)
+
+type placeholderEach struct {
+	Row  int
+	Path string
+}
+
+// excel_write_placeholders replaces the placeholders in all sheets of the workbook.
+// Rows marked with {{#each path}} are repeated for every entry of the list first,
+// afterwards all remaining {{path}} placeholders are replaced.
+func excel_write_placeholders(ctx context.Context, f *excelize.File, values interface{}) error {
+	for _, sheetName := range f.GetSheetList() {
+		if err := excel_write_placeholders_each(f, sheetName, values); err != nil {
+			return err
+		}
+		rows, err := f.GetRows(sheetName)
+		if err != nil {
+			return err
+		}
+		for rowIdx, row := range rows {
+			if err := excel_write_placeholders_row(f, sheetName, rowIdx+1, row, values); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func excel_write_placeholders_each(f *excelize.File, sheetName string, values interface{}) error {
+	rows, err := f.GetRows(sheetName)
+	if err != nil {
+		return err
+	}
+	eachRows := []placeholderEach{}
+	for rowIdx, row := range rows {
+		for _, text := range row {
+			if m := placeholderEachRegexp.FindStringSubmatch(text); m != nil {
+				eachRows = append(eachRows, placeholderEach{Row: rowIdx + 1, Path: m[1]})
+				break
+			}
+		}
+	}
+	// bottom up, so duplicated rows don't move the remaining markers
+	for idx := len(eachRows) - 1; idx >= 0; idx-- {
+		each := eachRows[idx]
+		listRaw, ok := excel_placeholder_lookup(values, each.Path)
+		if !ok {
+			return fmt.Errorf("sheet %s row %d: no value for list %s", sheetName, each.Row, each.Path)
+		}
+		list, ok := excel_placeholder_list(listRaw)
+		if !ok {
+			return fmt.Errorf("sheet %s row %d: %s is not a list", sheetName, each.Row, each.Path)
+		}
+		if len(list) == 0 {
+			if err := f.RemoveRow(sheetName, each.Row); err != nil {
+				return err
+			}
+			continue
+		}
+		for i := 1; i < len(list); i++ {
+			if err := f.DuplicateRow(sheetName, each.Row); err != nil {
+				return err
+			}
+		}
+		template := rows[each.Row-1]
+		for i, entry := range list {
+			if err := excel_write_placeholders_row(f, sheetName, each.Row+i, template, entry, values); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// excel_write_placeholders_row replaces the placeholders of a row, the scopes are searched in order
+func excel_write_placeholders_row(f *excelize.File, sheetName string, rowIdx int, row []string, scopes ...interface{}) error {
+	for colIdx, text := range row {
+		if !strings.Contains(text, "{{") {
+			continue
+		}
+		axis, err := excelize.CoordinatesToCellName(colIdx+1, rowIdx)
+		if err != nil {
+			return err
+		}
+		text = placeholderEndRegexp.ReplaceAllString(placeholderEachRegexp.ReplaceAllString(text, ""), "")
+		if m := placeholderRegexp.FindStringSubmatchIndex(text); m != nil && m[0] == 0 && m[1] == len(text) {
+			// a single placeholder keeps the type of the value
+			value, ok := excel_placeholder_scopes(scopes, text[m[2]:m[3]])
+			if !ok {
+				return fmt.Errorf("sheet %s cell %s: no value for placeholder %s", sheetName, axis, text)
+			}
+			if err := f.SetCellValue(sheetName, axis, value); err != nil {
+				return err
+			}
+			continue
+		}
+		var missing string
+		replaced := placeholderRegexp.ReplaceAllStringFunc(text, func(p string) string {
+			value, ok := excel_placeholder_scopes(scopes, placeholderRegexp.FindStringSubmatch(p)[1])
+			if !ok {
+				missing = p
+				return p
+			} else if value == nil {
+				return ""
+			}
+			return fmt.Sprint(value)
+		})
+		if missing != "" {
+			return fmt.Errorf("sheet %s cell %s: no value for placeholder %s", sheetName, axis, missing)
+		}
+		if err := f.SetCellValue(sheetName, axis, replaced); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func excel_placeholder_scopes(scopes []interface{}, path string) (interface{}, bool) {
+	for _, scope := range scopes {
+		if value, ok := excel_placeholder_lookup(scope, path); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// excel_placeholder_lookup resolves a dotted path like customer.address.city or rows.0.name,
+// this addresses the current entry inside an each row
+func excel_placeholder_lookup(value interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		if key == "this" {
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return nil, false
+			}
+		default:
+			list, ok := excel_placeholder_list(v)
+			if !ok {
+				return nil, false
+			}
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(list) {
+				return nil, false
+			}
+			value = list[idx]
+		}
+	}
+	return value, true
+}
+
+func excel_placeholder_list(value interface{}) ([]interface{}, bool) {
+	if list, ok := value.([]interface{}); ok {
+		return list, true
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}